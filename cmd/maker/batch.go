@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/agents/server"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+// Status of a batch entry.
+const (
+	batchCompleted = "completed"
+	batchFailed    = "failed"
+	batchSkipped   = "skipped"
+	batchCanceled  = "canceled"
+)
+
+// batchEntry is a line of a batch file: a ProjectRequest plus the id naming
+// its output directory.
+type batchEntry struct {
+	ID string `json:"id,omitempty"`
+	server.ProjectRequest
+
+	line int
+	hash string
+}
+
+// batchResult is a line of the results file.
+type batchResult struct {
+	ID          string       `json:"id"`
+	Line        int          `json:"line"`
+	Status      string       `json:"status"`
+	OutputDir   string       `json:"outputDir"`
+	Files       int          `json:"files"`
+	Usage       agents.Usage `json:"usage"`
+	CostUSD     float64      `json:"costUsd"`
+	DurationMs  int64        `json:"durationMs"`
+	Error       string       `json:"error,omitempty"`
+	RequestHash string       `json:"requestHash"`
+	FinishedAt  time.Time    `json:"finishedAt"`
+}
+
+var unsafeIDChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func batchCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, config.GenerateKeys...)
+	concurrency := fs.Int("concurrency", 2, "Number of requests generated at the same time")
+	resultsPath := fs.String("results", "", "Results file, appended as JSON lines (default: <output-dir>/batch-results.jsonl)")
+	force := fs.Bool("force", false, "Generate again the requests that already completed")
+	output := bindOutputFlags(fs)
+
+	return func(args []string) int {
+		report, code := output.reporter(fs)
+		if report == nil {
+			return code
+		}
+
+		if len(args) != 1 {
+			return usageError(fs, "expected one requests file")
+		}
+		if *concurrency < 1 {
+			return usageError(fs, "-concurrency must be at least 1")
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return fail(err)
+		}
+		if err := report.setLogger(cfg); err != nil {
+			return fail(err)
+		}
+		stopTracing, err := startTracing(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		defer stopTracing()
+		if cfg.APIKey == "" {
+			return fail(errNoAPIKey)
+		}
+
+		entries, err := readBatchFile(args[0])
+		if err != nil {
+			return fail(err)
+		}
+
+		if *resultsPath == "" {
+			*resultsPath = filepath.Join(cfg.OutputDir, "batch-results.jsonl")
+		}
+
+		previous, err := readBatchResults(*resultsPath)
+		if err != nil {
+			return fail(err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(*resultsPath), os.ModePerm); err != nil {
+			return fail(err)
+		}
+		resultsFile, err := os.OpenFile(*resultsPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fail(err)
+		}
+		defer resultsFile.Close()
+
+		catalog, err := loadCatalog(cfg)
+		if err != nil {
+			return fail(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		results := make([]batchResult, len(entries))
+
+		var runnable []int
+		for i, entry := range entries {
+			if prev, ok := previous[entry.ID]; ok && !*force && prev.Status == batchCompleted && prev.RequestHash == entry.hash {
+				prev.Status = batchSkipped
+				results[i] = prev
+				continue
+			}
+			runnable = append(runnable, i)
+		}
+
+		pending := make(chan int)
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			done      int
+			writeErrs []error
+		)
+
+		for w := 0; w < *concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for i := range pending {
+					entry := entries[i]
+					_, seen := previous[entry.ID]
+					result := runBatchEntry(ctx, cfg, catalog, report, entry, seen)
+
+					mu.Lock()
+					results[i] = result
+					done++
+					report.print("[%d/%d] %s %s (%d files, %d tokens, %s)\n",
+						done, len(runnable), result.ID, result.Status, result.Files,
+						result.Usage.TotalTokens, time.Duration(result.DurationMs)*time.Millisecond)
+
+					if result.Status != batchCanceled {
+						if err := writeBatchResult(resultsFile, result); err != nil {
+							writeErrs = append(writeErrs, err)
+						}
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+
+	dispatch:
+		for n, i := range runnable {
+			select {
+			case pending <- i:
+			case <-ctx.Done():
+				for _, j := range runnable[n:] {
+					results[j] = batchResult{ID: entries[j].ID, Line: entries[j].line, Status: batchCanceled}
+				}
+				break dispatch
+			}
+		}
+		close(pending)
+		wg.Wait()
+
+		if report.text() {
+			printBatchSummary(results)
+			fmt.Println("Results written to", *resultsPath)
+		}
+
+		if err := errors.Join(writeErrs...); err != nil {
+			return fail(err)
+		}
+
+		for _, result := range results {
+			if result.Status == batchFailed || result.Status == batchCanceled {
+				return exitFailure
+			}
+		}
+
+		return exitOK
+	}
+}
+
+// readBatchFile parses the requests of a batch file, one JSON object per
+// line, and checks their ids are unique.
+func readBatchFile(path string) ([]batchEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []batchEntry
+	ids := make(map[string]int)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 10<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		entry := batchEntry{line: line}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		if strings.TrimSpace(entry.Prompt) == "" {
+			return nil, fmt.Errorf("%s:%d: missing prompt", path, line)
+		}
+		if len(entry.Webhooks) > 0 {
+			slog.Warn("Webhooks are ignored in batch mode", "file", path, "line", line)
+		}
+
+		if entry.ID == "" {
+			entry.ID = entry.ProjectName
+		}
+		if entry.ID == "" {
+			entry.ID = fmt.Sprintf("request-%03d", line)
+		}
+		entry.ID = strings.Trim(unsafeIDChars.ReplaceAllString(entry.ID, "-"), "-.")
+		if entry.ID == "" {
+			return nil, fmt.Errorf("%s:%d: invalid id", path, line)
+		}
+
+		if first, ok := ids[entry.ID]; ok {
+			return nil, fmt.Errorf("%s:%d: id %s already used on line %d", path, line, entry.ID, first)
+		}
+		ids[entry.ID] = line
+
+		normalized, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(normalized)
+		entry.hash = hex.EncodeToString(sum[:])
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no requests", path)
+	}
+
+	return entries, nil
+}
+
+// readBatchResults returns the last result of every id in the results file,
+// if it exists.
+func readBatchResults(path string) (map[string]batchResult, error) {
+	results := make(map[string]batchResult)
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var result batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		results[result.ID] = result
+	}
+
+	return results, scanner.Err()
+}
+
+func writeBatchResult(f *os.File, result batchResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// runBatchEntry generates the project of entry in its own directory under
+// the output directory. A directory left by an earlier run of the entry is
+// removed first. Progress events are only reported in JSON mode, text mode
+// printing a line per entry.
+func runBatchEntry(ctx context.Context, base *config.Config, catalog *agents.Agent, report *reporter, entry batchEntry, rerun bool) batchResult {
+	cfg := *base
+	if entry.Template != "" {
+		cfg.Template = entry.Template
+	}
+	if entry.Language != "" {
+		cfg.Language = entry.Language
+	}
+	if entry.BasePackage != "" {
+		cfg.BasePackage = entry.BasePackage
+	}
+	if entry.Model != "" {
+		cfg.Model = entry.Model
+	}
+	if entry.WorkerCount > 0 {
+		cfg.WorkerCount = entry.WorkerCount
+	}
+	if entry.NoCache {
+		cfg.Cache.Disabled = true
+	}
+	if entry.Sampling != nil {
+		setSampling(&cfg, entry.Sampling.Params())
+	}
+
+	result := batchResult{
+		ID:          entry.ID,
+		Line:        entry.line,
+		OutputDir:   filepath.Join(cfg.OutputDir, entry.ID),
+		RequestHash: entry.hash,
+	}
+
+	started := time.Now()
+
+	ctx, span := tracing.Start(ctx, "generation",
+		"batch_id", entry.ID,
+		logging.TemplateKey, cfg.Template,
+		logging.ModelKey, cfg.Model,
+		logging.LanguageKey, cfg.Language)
+	defer span.End()
+
+	err := func() error {
+		if rerun {
+			if err := os.RemoveAll(result.OutputDir); err != nil {
+				return err
+			}
+		}
+
+		agent, err := newAgent(ctx, &cfg, result.OutputDir, report.callback(result.OutputDir))
+		if err != nil {
+			return err
+		}
+		agent.ShareCatalog(catalog)
+
+		if entry.ProjectName != "" {
+			agent.SetProjectName(entry.ProjectName)
+		}
+
+		params := make(map[string]string, len(entry.Params))
+		for name, value := range entry.Params {
+			params[name] = fmt.Sprint(value)
+		}
+		agent.SetParams(params)
+
+		if err := agent.UseAddons(entry.Addons...); err != nil {
+			return err
+		}
+
+		agent.Start()
+
+		if report.json() {
+			report.event(server.ProgressEvent{
+				Type:       "start",
+				Message:    "Starting code generation",
+				ProjectDir: result.OutputDir,
+			})
+		}
+
+		err = agent.GenerateCodeContext(ctx, entry.Prompt)
+		if err == nil {
+			time.Sleep(1 * time.Second)
+		}
+		agent.Stop()
+
+		result.Files = len(agent.WrittenFiles())
+		result.Usage = agent.Usage()
+		result.CostUSD = agents.EstimateCost(cfg.Model, result.Usage)
+
+		return err
+	}()
+
+	span.RecordError(err)
+
+	result.DurationMs = time.Since(started).Milliseconds()
+	result.FinishedAt = time.Now().UTC()
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		result.Status = batchCanceled
+		result.Error = ctx.Err().Error()
+	case err != nil:
+		result.Status = batchFailed
+		result.Error = err.Error()
+	default:
+		result.Status = batchCompleted
+	}
+
+	switch {
+	case err != nil && !report.text():
+		report.fail(fmt.Errorf("%s: %w", entry.ID, err), result.OutputDir)
+	case err == nil && report.json():
+		report.usage(result.OutputDir, cfg.Model, result.Usage)
+		report.event(server.ProgressEvent{
+			Type:       "complete",
+			Message:    "Code generation completed!",
+			ProjectDir: result.OutputDir,
+		})
+	}
+
+	return result
+}
+
+func printBatchSummary(results []batchResult) {
+	counts := make(map[string]int)
+	var total agents.Usage
+	var cost float64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tFILES\tTOKENS\tCOST\tDURATION\tERROR")
+
+	for _, result := range results {
+		counts[result.Status]++
+		if result.Status != batchSkipped {
+			total.Add(result.Usage)
+			cost += result.CostUSD
+		}
+
+		duration := time.Duration(result.DurationMs) * time.Millisecond
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t$%.4f\t%s\t%s\n",
+			result.ID, result.Status, result.Files, result.Usage.TotalTokens,
+			result.CostUSD, duration.Round(time.Millisecond), firstLine(result.Error))
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d completed, %d failed, %d skipped, %d canceled; %d tokens (~$%.4f)\n",
+		counts[batchCompleted], counts[batchFailed], counts[batchSkipped], counts[batchCanceled],
+		total.TotalTokens, cost)
+}
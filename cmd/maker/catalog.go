@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+// loadCatalog loads the templates without an OpenAI client, for the
+// commands that only read the catalog. Loading warnings are left to
+// maker templates validate.
+func loadCatalog(cfg *config.Config) (*agents.Agent, error) {
+	catalog, err := agents.NewAgentWithConfig(context.Background(), agents.AgentConfig{
+		OutputDir: ".",
+		Logger:    logging.Discard(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.TemplatesPath) > 0 {
+		if err := catalog.AddTemplatePaths(cfg.TemplatesPath...); err != nil {
+			return nil, err
+		}
+	}
+
+	return catalog, nil
+}
+
+func sortedTemplates(templates []agents.ProjectTemplate) []agents.ProjectTemplate {
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates
+}
+
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fail(err)
+	}
+
+	return exitOK
+}
+
+func printTemplates(catalog *agents.Agent) {
+	fmt.Println("Available Templates:")
+	for _, tmpl := range sortedTemplates(catalog.ListTemplates()) {
+		fmt.Printf("- %s: %s (Languages: %s)\n", tmpl.Name, tmpl.Description, tmpl.Language)
+
+		if resolved, err := catalog.ResolveTemplate(tmpl.Name); err == nil {
+			for _, p := range resolved.Parameters {
+				fmt.Printf("    -param %s=<%s> %s (default: %v)\n", p.Name, p.Type, p.Description, p.Default)
+			}
+		}
+	}
+
+	fmt.Println("Available add-ons:")
+	for _, addon := range sortedTemplates(catalog.ListAddons()) {
+		fmt.Printf("- %s: %s\n", addon.Name, addon.Description)
+	}
+}
+
+func printLanguages(catalog *agents.Agent) {
+	languages := catalog.Listlanguages()
+	sort.Strings(languages)
+
+	fmt.Println("Available languages:")
+	for _, lang := range languages {
+		fmt.Printf("- %s\n", lang)
+	}
+}
+
+func listTemplatesCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, "templates_path")
+	jsonOutput := fs.Bool("json", false, "Print the templates and add-ons as JSON")
+	names := fs.Bool("names", false, "Print only the template names, one per line")
+
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return fail(err)
+		}
+
+		catalog, err := loadCatalog(cfg)
+		if err != nil {
+			return fail(err)
+		}
+
+		switch {
+		case *jsonOutput:
+			return printJSON(map[string]interface{}{
+				"templates": sortedTemplates(catalog.ListTemplates()),
+				"addons":    sortedTemplates(catalog.ListAddons()),
+			})
+		case *names:
+			for _, tmpl := range sortedTemplates(catalog.ListTemplates()) {
+				fmt.Println(tmpl.Name)
+			}
+		default:
+			printTemplates(catalog)
+		}
+
+		return exitOK
+	}
+}
+
+func showTemplateCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, "templates_path")
+	addons := fs.String("addons", "", "Comma separated add-ons to apply")
+	jsonOutput := fs.Bool("json", false, "Print the resolved template as JSON")
+
+	return func(args []string) int {
+		if len(args) != 1 {
+			return usageError(fs, "expected one template name")
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return fail(err)
+		}
+
+		catalog, err := loadCatalog(cfg)
+		if err != nil {
+			return fail(err)
+		}
+
+		var extra []string
+		if *addons != "" {
+			extra = strings.Split(*addons, ",")
+		}
+
+		tmpl, err := catalog.ResolveTemplate(args[0], extra...)
+		if err != nil {
+			return fail(err)
+		}
+
+		if *jsonOutput {
+			return printJSON(tmpl)
+		}
+
+		fmt.Printf("Name:        %s\n", tmpl.Name)
+		fmt.Printf("Description: %s\n", tmpl.Description)
+		fmt.Printf("Language:    %s\n", tmpl.Language)
+		if tmpl.Extends != "" {
+			fmt.Printf("Extends:     %s\n", tmpl.Extends)
+		}
+		if len(tmpl.Mixins) > 0 {
+			fmt.Printf("Add-ons:     %s\n", strings.Join(tmpl.Mixins, ", "))
+		}
+
+		if len(tmpl.Parameters) > 0 {
+			fmt.Println("\nParameters:")
+			for _, p := range tmpl.Parameters {
+				fmt.Printf("  %s <%s> %s (default: %v)\n", p.Name, p.Type, p.Description, p.Default)
+			}
+		}
+
+		if files := tmpl.FilePaths(); len(files) > 0 {
+			fmt.Println("\nFiles:")
+			for _, file := range files {
+				fmt.Println("  " + file)
+			}
+		}
+
+		if tmpl.Prompt != "" {
+			fmt.Printf("\nPrompt:\n%s\n", strings.TrimSpace(tmpl.Prompt))
+		}
+		if tmpl.SystemPrompt != "" {
+			fmt.Printf("\nSystem prompt:\n%s\n", strings.TrimSpace(tmpl.SystemPrompt))
+		}
+		if len(tmpl.Examples) > 0 {
+			fmt.Printf("\nExamples: %d\n", len(tmpl.Examples))
+		}
+		if len(tmpl.References) > 0 {
+			fmt.Printf("References: %s\n", strings.Join(tmpl.References, ", "))
+		}
+
+		return exitOK
+	}
+}
+
+func languagesCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, "templates_path")
+	jsonOutput := fs.Bool("json", false, "Print the languages as JSON")
+	names := fs.Bool("names", false, "Print only the language names, one per line")
+
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return fail(err)
+		}
+
+		catalog, err := loadCatalog(cfg)
+		if err != nil {
+			return fail(err)
+		}
+
+		languages := catalog.Listlanguages()
+		sort.Strings(languages)
+
+		switch {
+		case *jsonOutput:
+			return printJSON(languages)
+		case *names:
+			for _, lang := range languages {
+				fmt.Println(lang)
+			}
+			return exitOK
+		}
+
+		printLanguages(catalog)
+
+		return exitOK
+	}
+}
+
+func modelsCommand(fs *flag.FlagSet) func(args []string) int {
+	jsonOutput := fs.Bool("json", false, "Print the models as JSON")
+	names := fs.Bool("names", false, "Print only the model IDs, one per line")
+
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		switch {
+		case *jsonOutput:
+			return printJSON(agents.Models)
+		case *names:
+			for _, model := range agents.Models {
+				fmt.Println(model.ID)
+			}
+			return exitOK
+		}
+
+		fmt.Println("Available models:")
+		for _, model := range agents.Models {
+			fmt.Printf("- %s: %s\n", model.ID, model.Description)
+		}
+
+		return exitOK
+	}
+}
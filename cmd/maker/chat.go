@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+const chatHelp = `Type a change request, ending a line with \ to continue it on the next one.
+Commands:
+  /files          List the project files, marking those changed in this session
+  /undo           Revert the last applied changes
+  /model [name]   Show or switch the model
+  /cost           Show the tokens used and their estimated cost
+  /help           Show this help
+  /exit           Leave the session (also Ctrl+D)`
+
+func chatCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, config.ModelKeys...)
+	prompt := bindPromptFlags(fs)
+	dir := fs.String("dir", ".", "Directory of the project, created if missing")
+	yes := fs.Bool("yes", false, "Apply every proposed change without asking")
+	verbose := fs.Bool("verbose", false, "Show the logs on stderr")
+
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return fail(err)
+		}
+
+		contextFiles, err := prompt.contextFiles()
+		if err != nil {
+			return fail(err)
+		}
+
+		if err := os.MkdirAll(*dir, os.ModePerm); err != nil {
+			return fail(err)
+		}
+
+		// sending the same request again asks for a new answer
+		cfg.Cache.Disabled = true
+
+		logger, err := newLogger(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		if !*verbose {
+			logger = logging.Discard()
+		}
+		slog.SetDefault(logger)
+
+		stopTracing, err := startTracing(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		defer stopTracing()
+
+		ctx, span := tracing.Start(context.Background(), "chat", logging.ModelKey, cfg.Model)
+		defer span.End()
+
+		agent, err := newAgent(ctx, cfg, *dir, nil)
+		if err != nil {
+			return fail(err)
+		}
+		agent.SetContextFiles(contextFiles)
+
+		chat := &chatREPL{
+			ctx:     ctx,
+			session: agent.NewChatSessionContext(ctx),
+			agent:   agent,
+			in:      bufio.NewReader(os.Stdin),
+			color:   isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "",
+			yes:     *yes,
+		}
+
+		fmt.Printf("Chatting about %s with %s. Type /help for commands.\n", *dir, chat.session.Model())
+
+		// the initial request can come from -prompt-file
+		if prompt.given() {
+			request, err := prompt.read(nil)
+			if err != nil {
+				return fail(err)
+			}
+			chat.send(request)
+		}
+
+		return chat.run()
+	}
+}
+
+// chatREPL reads requests and commands from the terminal and reviews the
+// proposed changes with the user.
+type chatREPL struct {
+	// ctx carries the span of the session.
+	ctx     context.Context
+	session *agents.ChatSession
+	agent   *agents.Agent
+	in      *bufio.Reader
+	color   bool
+	yes     bool
+}
+
+func (r *chatREPL) run() int {
+	for {
+		fmt.Print("> ")
+
+		line, err := r.readInput()
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			return exitOK
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "/"):
+			if r.command(line) {
+				return exitOK
+			}
+		default:
+			r.send(line)
+		}
+	}
+}
+
+// readInput reads a request, joining the lines ending with a backslash.
+func (r *chatREPL) readInput() (string, error) {
+	var lines []string
+
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			if len(lines) > 0 {
+				break
+			}
+			return "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasSuffix(line, "\\") {
+			lines = append(lines, line)
+			break
+		}
+
+		lines = append(lines, strings.TrimSuffix(line, "\\"))
+		fmt.Print(". ")
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// ask prompts for one of the answers, the first being the default.
+func (r *chatREPL) ask(question string, answers ...string) (string, error) {
+	for {
+		fmt.Printf("%s [%s] ", question, strings.Join(answers, "/"))
+
+		line, err := r.in.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if err != nil && answer == "" {
+			return "", err
+		}
+
+		if answer == "" {
+			return answers[0], nil
+		}
+		for _, a := range answers {
+			if answer == a || answer == a[:1] {
+				return a, nil
+			}
+		}
+	}
+}
+
+func (r *chatREPL) send(request string) {
+	fmt.Println("Thinking... (Ctrl+C to cancel)")
+
+	// Ctrl+C cancels the turn, not the session
+	ctx, stop := signal.NotifyContext(r.ctx, os.Interrupt)
+	turn, err := r.session.SendContext(ctx, request)
+	stop()
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Cancelled.")
+		return
+	}
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+
+	if turn.Reply != "" {
+		fmt.Println(turn.Reply)
+	}
+
+	if len(turn.Changes) == 0 {
+		fmt.Println("No file changes proposed.")
+		return
+	}
+
+	accepted, rejected, err := r.review(turn.Changes)
+	if err != nil {
+		fmt.Println("error:", err)
+	}
+
+	if err := r.session.Apply(accepted); err != nil {
+		fmt.Println("error applying changes:", err)
+	}
+	r.session.Reject(rejected)
+
+	fmt.Printf("Applied %d of %d changes (%d tokens, ~$%.4f).\n", len(accepted), len(turn.Changes), turn.Usage.TotalTokens, turn.Cost)
+}
+
+// review shows the diff of each change and asks whether to apply it.
+func (r *chatREPL) review(changes []agents.FileChange) (accepted, rejected []agents.FileChange, err error) {
+	all := r.yes
+
+	for i, change := range changes {
+		action := "Modify"
+		if change.Created {
+			action = "Create"
+		}
+
+		fmt.Println()
+		fmt.Print(unifiedDiff(change.Path, change.Old, change.New, change.Created, r.color))
+
+		if all {
+			accepted = append(accepted, change)
+			continue
+		}
+
+		answer, err := r.ask(fmt.Sprintf("%s %s? (%d/%d)", action, change.Path, i+1, len(changes)), "yes", "no", "all", "quit")
+		if err != nil {
+			return accepted, append(rejected, changes[i:]...), err
+		}
+
+		switch answer {
+		case "yes":
+			accepted = append(accepted, change)
+		case "no":
+			rejected = append(rejected, change)
+		case "all":
+			all = true
+			accepted = append(accepted, change)
+		case "quit":
+			return accepted, append(rejected, changes[i:]...), nil
+		}
+	}
+
+	return accepted, rejected, nil
+}
+
+// command runs a slash command and reports whether the session ends.
+func (r *chatREPL) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/help":
+		fmt.Println(chatHelp)
+	case "/files":
+		files, err := r.session.Files()
+		if err != nil {
+			fmt.Println("error:", err)
+			return false
+		}
+
+		changed := make(map[string]bool)
+		for _, path := range r.agent.WrittenFiles() {
+			changed[path] = true
+		}
+
+		if len(files) == 0 {
+			fmt.Println("The project is empty.")
+		}
+		for _, path := range files {
+			mark := " "
+			if changed[path] {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\n", mark, path)
+		}
+	case "/undo":
+		changes, err := r.session.Undo()
+		if err != nil {
+			fmt.Println("error:", err)
+			return false
+		}
+
+		for _, change := range changes {
+			if change.Created {
+				fmt.Println("Removed", change.Path)
+			} else {
+				fmt.Println("Restored", change.Path)
+			}
+		}
+	case "/model":
+		if arg != "" {
+			r.session.SetModel(arg)
+		}
+		fmt.Println("Model:", r.session.Model())
+	case "/cost":
+		usage, cost := r.session.Cost()
+		fmt.Printf("%d tokens (%d prompt, %d completion), ~$%.4f\n", usage.TotalTokens, usage.PromptTokens, usage.CompletionTokens, cost)
+	default:
+		fmt.Printf("Unknown command %s, type /help for the list.\n", name)
+	}
+
+	return false
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
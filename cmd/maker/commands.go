@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Exit codes of every maker command.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a node of the maker command tree. Groups hold subcommands;
+// leaf commands define their flags in setup, which returns the function
+// running the command with the arguments left after the flags.
+type command struct {
+	name     string
+	args     string
+	summary  string
+	setup    func(fs *flag.FlagSet) func(args []string) int
+	commands []*command
+}
+
+// commandTree returns the maker commands. It is a function rather than a
+// variable because help and completion walk the tree themselves.
+func commandTree() *command {
+	return &command{
+		name: "maker",
+		commands: []*command{
+			{name: "generate", args: "<prompt>", summary: "Generate a project from a prompt", setup: generateCommand},
+			{name: "batch", args: "<requests.jsonl>", summary: "Generate a project for each request of a JSON lines file", setup: batchCommand},
+			{name: "edit", args: "<instruction>", summary: "Change an existing project following an instruction", setup: editCommand},
+			{name: "chat", summary: "Change a project in a conversation, reviewing each proposed file", setup: chatCommand},
+			{name: "templates", summary: "List, inspect, validate and manage templates", commands: []*command{
+				{name: "list", summary: "List the templates and add-ons", setup: listTemplatesCommand},
+				{name: "show", args: "<template>", summary: "Show a template with its parents and add-ons applied", setup: showTemplateCommand},
+				{name: "validate", args: "[dir...]", summary: "Validate the embedded templates and those in the given directories", setup: validateTemplatesCommand},
+				{name: "capture", args: "<project dir>", summary: "Turn an existing project into a template package", setup: captureTemplateCommand},
+				{name: "fetch", args: "<source>", summary: "Download templates from a git repository, an archive URL or a file:// path", setup: fetchTemplatesCommand},
+				{name: "sources", summary: "List the template search paths and fetched sources", setup: templateSourcesCommand},
+			}},
+			{name: "languages", summary: "List the supported programming languages", setup: languagesCommand},
+			{name: "models", summary: "List the OpenAI models", setup: modelsCommand},
+			{name: "serve", summary: "Start the web server", setup: serveCommand},
+			{name: "config", summary: "Show the configuration and the directories maker uses", commands: []*command{
+				{name: "show", summary: "Show the effective settings and where each value comes from", setup: showConfigCommand},
+				{name: "paths", summary: "Show the config files, template directories and search paths", setup: configPathsCommand},
+			}},
+			{name: "completion", args: "bash|zsh|fish", summary: "Print the shell completion script", setup: completionCommand},
+		},
+	}
+}
+
+func (c *command) find(name string) *command {
+	for _, sub := range c.commands {
+		if sub.name == name {
+			return sub
+		}
+	}
+
+	return nil
+}
+
+// execute runs the command named by args under c; path holds the names of
+// c and its parents.
+func (c *command) execute(path []string, args []string) int {
+	path = append(path, c.name)
+
+	if c.setup != nil {
+		fs, run := c.flagSet(path)
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return exitOK
+			}
+			return exitUsage
+		}
+		return run(fs.Args())
+	}
+
+	if len(args) == 0 {
+		c.usage(os.Stderr, path)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		c.usage(os.Stdout, path)
+		return exitOK
+	}
+
+	sub := c.find(args[0])
+	if sub == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join(append(path[1:], args[0]), " "))
+		c.usage(os.Stderr, path)
+		return exitUsage
+	}
+
+	return sub.execute(path, args[1:])
+}
+
+// flagSet builds the flags of a leaf command, with a usage message listing
+// them.
+func (c *command) flagSet(path []string) (*flag.FlagSet, func(args []string) int) {
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	run := c.setup(fs)
+
+	fs.Usage = func() {
+		c.usage(fs.Output(), path)
+	}
+
+	return fs, run
+}
+
+func (c *command) usage(w io.Writer, path []string) {
+	name := strings.Join(path, " ")
+
+	if c.setup == nil {
+		if c.summary != "" {
+			fmt.Fprintf(w, "%s\n\n", c.summary)
+		}
+		fmt.Fprintf(w, "usage: %s <command> [flags] [arguments]\n\nCommands:\n", name)
+		for _, sub := range c.commands {
+			fmt.Fprintf(w, "  %-12s %s\n", sub.name, sub.summary)
+		}
+		fmt.Fprintf(w, "\nRun \"%s <command> -h\" for the flags of a command.\n", name)
+		return
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.setup(fs)
+
+	fmt.Fprintf(w, "usage: %s", name)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprint(w, " [flags]")
+	}
+	if c.args != "" {
+		fmt.Fprint(w, " "+c.args)
+	}
+	fmt.Fprintf(w, "\n\n%s\n", c.summary)
+
+	if hasFlags {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// usageError reports wrong arguments of a leaf command.
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(fs.Output(), format+"\n\n", args...)
+	fs.Usage()
+
+	return exitUsage
+}
+
+// fail reports the error of a command.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "error:", err)
+
+	return exitFailure
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// flagValues are the shell commands listing the values of flags, and of the
+// arguments of commands, that completion offers.
+var flagValues = map[string]string{
+	"template": "maker templates list -names",
+	"language": "maker languages -names",
+	"model":    "maker models -names",
+}
+
+var argValues = map[string]string{
+	"templates show": "maker templates list -names",
+	"completion":     "echo bash zsh fish",
+}
+
+// completionNode is a command of the tree as completion sees it: its path
+// below maker and its subcommands or flags.
+type completionNode struct {
+	path    string
+	command *command
+	flags   []*flag.Flag
+}
+
+func (n completionNode) isGroup() bool {
+	return n.command.setup == nil
+}
+
+// completionNodes walks the command tree, groups first so that shells can
+// match group paths exactly and leaf paths by prefix.
+func completionNodes(root *command) []completionNode {
+	var nodes []completionNode
+
+	var walk func(c *command, path []string)
+	walk = func(c *command, path []string) {
+		node := completionNode{path: strings.Join(path, " "), command: c}
+
+		if c.setup != nil {
+			fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+			c.setup(fs)
+			fs.VisitAll(func(f *flag.Flag) {
+				node.flags = append(node.flags, f)
+			})
+		}
+
+		nodes = append(nodes, node)
+
+		for _, sub := range c.commands {
+			walk(sub, append(append([]string{}, path...), sub.name))
+		}
+	}
+	walk(root, nil)
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].isGroup() && !nodes[j].isGroup()
+	})
+
+	return nodes
+}
+
+// valueFlags returns the flags taking a value, over every command.
+func valueFlags(nodes []completionNode) []string {
+	seen := make(map[string]bool)
+
+	for _, node := range nodes {
+		for _, f := range node.flags {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				continue
+			}
+			seen[f.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(s, "\n")
+	return s
+}
+
+func singleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func completionCommand(fs *flag.FlagSet) func(args []string) int {
+	return func(args []string) int {
+		if len(args) != 1 {
+			return usageError(fs, "expected one shell: bash, zsh or fish")
+		}
+
+		nodes := completionNodes(commandTree())
+
+		switch args[0] {
+		case "bash":
+			writeBashCompletion(os.Stdout, nodes)
+		case "zsh":
+			writeZshCompletion(os.Stdout, nodes)
+		case "fish":
+			writeFishCompletion(os.Stdout, nodes)
+		default:
+			return usageError(fs, "unsupported shell %q, expected bash, zsh or fish", args[0])
+		}
+
+		return exitOK
+	}
+}
+
+func writeBashCompletion(w io.Writer, nodes []completionNode) {
+	fmt.Fprint(w, `# bash completion for maker, generated by "maker completion bash".
+# Load it with: source <(maker completion bash)
+_maker() {
+    local cur prev cmd_path word i opts
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    cmd_path=""
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        word="${COMP_WORDS[i]}"
+        case "$word" in
+            -*) ;;
+            *) cmd_path="$cmd_path $word" ;;
+        esac
+    done
+    cmd_path="${cmd_path# }"
+
+    case "$prev" in
+`)
+
+	for _, name := range sortedKeys(flagValues) {
+		fmt.Fprintf(w, "        -%s) COMPREPLY=($(compgen -W \"$(%s 2>/dev/null)\" -- \"$cur\")); return ;;\n", name, flagValues[name])
+	}
+
+	fmt.Fprintf(w, "        %s) return ;;\n", dashed(valueFlags(nodes), "|"))
+	fmt.Fprint(w, "    esac\n\n    opts=\"\"\n    case \"$cmd_path\" in\n")
+
+	for _, node := range nodes {
+		if node.isGroup() {
+			names := make([]string, 0, len(node.command.commands))
+			for _, sub := range node.command.commands {
+				names = append(names, sub.name)
+			}
+			fmt.Fprintf(w, "        %q) opts=%q ;;\n", node.path, strings.Join(names, " "))
+			continue
+		}
+
+		fmt.Fprintf(w, "        %q*)\n", node.path)
+		fmt.Fprintf(w, "            if [[ \"$cur\" == -* ]]; then\n                opts=%q\n", dashed(flagNames(node.flags), " "))
+		if values, ok := argValues[node.path]; ok {
+			fmt.Fprintf(w, "            else\n                opts=\"$(%s 2>/dev/null)\"\n", values)
+		}
+		fmt.Fprint(w, "            fi ;;\n")
+	}
+
+	fmt.Fprint(w, `    esac
+
+    COMPREPLY=($(compgen -W "$opts" -- "$cur"))
+}
+complete -o default -F _maker maker
+`)
+}
+
+func writeZshCompletion(w io.Writer, nodes []completionNode) {
+	fmt.Fprint(w, `#compdef maker
+# zsh completion for maker, generated by "maker completion zsh".
+# Put it in a directory of $fpath as _maker, or load it with:
+# source <(maker completion zsh)
+compdef _maker maker
+
+_maker() {
+    local -a path_words opts
+    local word i cmd_path cur prev
+    for ((i = 2; i < CURRENT; i++)); do
+        word="${words[i]}"
+        [[ "$word" == -* ]] || path_words+=("$word")
+    done
+    cmd_path="${(j: :)path_words}"
+    cur="${words[CURRENT]}"
+    prev="${words[CURRENT-1]}"
+
+    case "$prev" in
+`)
+
+	for _, name := range sortedKeys(flagValues) {
+		fmt.Fprintf(w, "        -%s) compadd -- $(%s 2>/dev/null); return ;;\n", name, flagValues[name])
+	}
+
+	fmt.Fprintf(w, "        %s) _files; return ;;\n", dashed(valueFlags(nodes), "|"))
+	fmt.Fprint(w, "    esac\n\n    case \"$cmd_path\" in\n")
+
+	for _, node := range nodes {
+		if node.isGroup() {
+			fmt.Fprintf(w, "        %q)\n            opts=(\n", node.path)
+			for _, sub := range node.command.commands {
+				fmt.Fprintf(w, "                %s\n", singleQuote(sub.name+":"+sub.summary))
+			}
+			fmt.Fprint(w, "            )\n            _describe -t commands 'maker command' opts ;;\n")
+			continue
+		}
+
+		fmt.Fprintf(w, "        %q*)\n            if [[ \"$cur\" == -* ]]; then\n                opts=(\n", node.path)
+		for _, f := range node.flags {
+			fmt.Fprintf(w, "                    %s\n", singleQuote("-"+f.Name+":"+firstLine(f.Usage)))
+		}
+		fmt.Fprint(w, "                )\n                _describe -t flags 'flag' opts\n")
+		if values, ok := argValues[node.path]; ok {
+			fmt.Fprintf(w, "            else\n                compadd -- $(%s 2>/dev/null)\n", values)
+		} else {
+			fmt.Fprint(w, "            else\n                _files\n")
+		}
+		fmt.Fprint(w, "            fi ;;\n")
+	}
+
+	fmt.Fprint(w, `    esac
+}
+
+# run the completion function when the file is autoloaded from $fpath
+if [ "$funcstack[1]" = "_maker" ]; then
+    _maker "$@"
+fi
+`)
+}
+
+func writeFishCompletion(w io.Writer, nodes []completionNode) {
+	fmt.Fprint(w, `# fish completion for maker, generated by "maker completion fish".
+# Load it with: maker completion fish | source
+function __maker_path
+    set -l words (commandline -opc)
+    set -e words[1]
+    set -l result
+    for word in $words
+        string match -q -- '-*' $word; or set -a result $word
+    end
+    echo "$result"
+end
+
+function __maker_is
+    set -l p (__maker_path)
+    test "$p" = "$argv"
+end
+
+function __maker_in
+    set -l p (__maker_path)
+    string match -q -- "$argv*" "$p"
+end
+
+complete -c maker -f
+`)
+
+	for _, node := range nodes {
+		if node.isGroup() {
+			for _, sub := range node.command.commands {
+				fmt.Fprintf(w, "complete -c maker -n %s -a %s -d %s\n",
+					singleQuote(`__maker_is "`+node.path+`"`), sub.name, singleQuote(sub.summary))
+			}
+			continue
+		}
+
+		condition := singleQuote(`__maker_in "` + node.path + `"`)
+
+		if values, ok := argValues[node.path]; ok {
+			fmt.Fprintf(w, "complete -c maker -n %s -a %s\n", condition, singleQuote("("+values+" 2>/dev/null)"))
+		} else {
+			fmt.Fprintf(w, "complete -c maker -n %s -F\n", condition)
+		}
+
+		for _, f := range node.flags {
+			line := fmt.Sprintf("complete -c maker -n %s -o %s -d %s", condition, f.Name, singleQuote(firstLine(f.Usage)))
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				line += " -r"
+				if values, ok := flagValues[f.Name]; ok {
+					line += " -a " + singleQuote("("+values+" 2>/dev/null)")
+				}
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func flagNames(flags []*flag.Flag) []string {
+	names := make([]string, 0, len(flags))
+	for _, f := range flags {
+		names = append(names, f.Name)
+	}
+
+	return names
+}
+
+// dashed prefixes names with a dash and joins them with sep.
+func dashed(names []string, sep string) string {
+	for i, name := range names {
+		names[i] = "-" + name
+	}
+
+	return strings.Join(names, sep)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
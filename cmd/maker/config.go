@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/cache"
+	"github.com/lFer17/codebase-maker/internal/config"
+)
+
+const redacted = "********"
+
+func showConfigCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs)
+	jsonOutput := fs.Bool("json", false, "Print the settings as JSON")
+
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		resolved, err := loader.Load()
+		if err != nil {
+			return fail(err)
+		}
+
+		type setting struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Source string `json:"source"`
+		}
+
+		var values []setting
+		for _, s := range config.Settings() {
+			value := s.Get(resolved.Config)
+			if s.Secret && value != "" {
+				value = redacted
+			}
+			values = append(values, setting{Key: s.Key, Value: value, Source: resolved.Sources[s.Key]})
+		}
+
+		if *jsonOutput {
+			return printJSON(map[string]interface{}{
+				"profile":  resolved.Profile,
+				"files":    resolved.Files,
+				"settings": values,
+			})
+		}
+
+		if resolved.Profile != "" {
+			fmt.Println("Profile:", resolved.Profile)
+		}
+		if len(resolved.Files) > 0 {
+			fmt.Println("Config files:")
+			for _, file := range resolved.Files {
+				fmt.Println("  " + file)
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, s := range values {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+		}
+		w.Flush()
+
+		return exitOK
+	}
+}
+
+func configPathsCommand(fs *flag.FlagSet) func(args []string) int {
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		if path, err := config.UserFile(); err == nil {
+			fmt.Println("User config:", path)
+		}
+		if path, ok := config.FindProjectFile("."); ok {
+			fmt.Println("Project config:", path)
+		}
+		if dir, err := agents.UserTemplateDir(); err == nil {
+			fmt.Println("User templates:", dir)
+		}
+		if dir, err := agents.TemplateCacheDir(); err == nil {
+			fmt.Println("Template cache:", dir)
+		}
+		if dir, err := cache.DefaultDir(); err == nil {
+			fmt.Println("Response cache:", dir)
+		}
+
+		printTemplatePaths()
+
+		return exitOK
+	}
+}
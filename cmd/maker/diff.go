@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines around each hunk.
+	diffContext = 3
+	// maxDiffCells bounds the line comparison table; larger files are shown
+	// as a whole replacement.
+	maxDiffCells = 4 << 20
+
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorCyan  = "\033[36m"
+)
+
+// diffOp is a line of a diff: kept (' '), removed ('-') or added ('+'),
+// with its 1-based line numbers in the old and new texts.
+type diffOp struct {
+	kind    byte
+	text    string
+	oldLine int
+	newLine int
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines compares the lines of a and b through their longest common
+// subsequence.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+
+	if len(a)*len(b) > maxDiffCells {
+		for i, line := range a {
+			ops = append(ops, diffOp{kind: '-', text: line, oldLine: i + 1, newLine: 1})
+		}
+		for j, line := range b {
+			ops = append(ops, diffOp{kind: '+', text: line, oldLine: len(a) + 1, newLine: j + 1})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', text: a[i], oldLine: i + 1, newLine: j + 1})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			ops = append(ops, diffOp{kind: '+', text: b[j], oldLine: i + 1, newLine: j + 1})
+			j++
+		default:
+			ops = append(ops, diffOp{kind: '-', text: a[i], oldLine: i + 1, newLine: j + 1})
+			i++
+		}
+	}
+
+	return ops
+}
+
+// unifiedDiff renders the changes from old to new of the file at path as a
+// unified diff, colored for terminals.
+func unifiedDiff(path, old, new string, created, color bool) string {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+
+	var b strings.Builder
+
+	from := "a/" + path
+	if created {
+		from = "/dev/null"
+	}
+	b.WriteString(paint(colorRed, "--- "+from) + "\n")
+	b.WriteString(paint(colorGreen, "+++ b/"+path) + "\n")
+
+	ops := diffLines(splitLines(old), splitLines(new))
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(0, i-diffContext)
+		end := i
+
+		// extend the hunk over changes separated by little context
+		for j := i; j < len(ops); {
+			if ops[j].kind != ' ' {
+				end = j
+				j++
+				continue
+			}
+
+			k := j
+			for k < len(ops) && ops[k].kind == ' ' {
+				k++
+			}
+			if k == len(ops) || k-j > 2*diffContext {
+				break
+			}
+			j = k
+		}
+
+		stop := min(len(ops), end+diffContext+1)
+		hunk := ops[start:stop]
+
+		oldCount, newCount := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+
+		oldStart, newStart := hunk[0].oldLine, hunk[0].newLine
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		b.WriteString(paint(colorCyan, fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)) + "\n")
+
+		for _, op := range hunk {
+			line := string(op.kind) + op.text
+			switch op.kind {
+			case '-':
+				line = paint(colorRed, line)
+			case '+':
+				line = paint(colorGreen, line)
+			}
+			b.WriteString(line + "\n")
+		}
+
+		i = stop
+	}
+
+	return b.String()
+}
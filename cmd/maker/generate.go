@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/agents/server"
+	"github.com/lFer17/codebase-maker/internal/cache"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+var errNoAPIKey = errors.New("please provide an OpenAI API key using the -openai-key flag, the api_key setting or the OPENAI_KEY environment variable")
+
+// loadConfig resolves the configuration once the flags bound to loader are
+// parsed.
+func loadConfig(loader *config.Loader) (*config.Config, error) {
+	resolved, err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved.Config, nil
+}
+
+// newCache returns the response cache of cfg, nil when disabled.
+func newCache(cfg *config.Config) (*cache.Cache, error) {
+	if cfg.Cache.Disabled {
+		return nil, nil
+	}
+
+	return cache.New(cfg.Cache.Dir, cfg.Cache.TTL, int64(cfg.Cache.MaxSize)<<20)
+}
+
+// newSampling parses the sampling settings of cfg. Unset settings stay
+// unset, leaving them to the template or the API.
+func newSampling(cfg *config.Config) (agents.SamplingParams, error) {
+	s := cfg.Sampling
+	sampling := agents.SamplingParams{
+		MaxTokens:       s.MaxTokens,
+		Stop:            s.Stop,
+		ReasoningEffort: s.ReasoningEffort,
+	}
+
+	parseFloat := func(key, raw string) (*float64, error) {
+		if raw == "" {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return nil, fmt.Errorf("sampling.%s: %q is not a number", key, raw)
+		}
+		return &v, nil
+	}
+
+	var err error
+	if sampling.Temperature, err = parseFloat("temperature", s.Temperature); err != nil {
+		return sampling, err
+	}
+	if sampling.TopP, err = parseFloat("top_p", s.TopP); err != nil {
+		return sampling, err
+	}
+
+	if s.Seed != "" {
+		seed, err := strconv.Atoi(strings.TrimSpace(s.Seed))
+		if err != nil {
+			return sampling, fmt.Errorf("sampling.seed: %q is not an integer", s.Seed)
+		}
+		sampling.Seed = &seed
+	}
+
+	return sampling, sampling.Validate()
+}
+
+// setSampling writes the parameters set in sampling over the sampling
+// settings of cfg.
+func setSampling(cfg *config.Config, sampling agents.SamplingParams) {
+	if sampling.Temperature != nil {
+		cfg.Sampling.Temperature = strconv.FormatFloat(*sampling.Temperature, 'g', -1, 64)
+	}
+	if sampling.TopP != nil {
+		cfg.Sampling.TopP = strconv.FormatFloat(*sampling.TopP, 'g', -1, 64)
+	}
+	if sampling.MaxTokens != 0 {
+		cfg.Sampling.MaxTokens = sampling.MaxTokens
+	}
+	if sampling.Seed != nil {
+		cfg.Sampling.Seed = strconv.Itoa(*sampling.Seed)
+	}
+	if len(sampling.Stop) > 0 {
+		cfg.Sampling.Stop = sampling.Stop
+	}
+	if sampling.ReasoningEffort != "" {
+		cfg.Sampling.ReasoningEffort = sampling.ReasoningEffort
+	}
+}
+
+// newAgent creates an agent writing to outputDir, with the templates of the
+// search paths and the templates_path setting. callBack may be nil.
+func newAgent(ctx context.Context, cfg *config.Config, outputDir string, callBack agents.ProgressCallBack) (*agents.Agent, error) {
+	if cfg.APIKey == "" {
+		return nil, errNoAPIKey
+	}
+
+	responses, err := newCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sampling, err := newSampling(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := agents.NewOpenAIWithConfig(ctx, agents.OpenAIConfig{
+		APIKey:  cfg.APIKey,
+		Model:   cfg.Model,
+		BaseURL: cfg.BaseURL,
+		Retries: cfg.Retries,
+		HTTPClient: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		},
+		Cache:    responses,
+		Sampling: sampling,
+	})
+
+	agent, err := agents.NewAgentWithCallback(ctx, client, outputDir, cfg.BasePackage, cfg.Template, cfg.Language, cfg.WorkerCount, callBack)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.TemplatesPath) > 0 {
+		if err := agent.AddTemplatePaths(cfg.TemplatesPath...); err != nil {
+			return nil, err
+		}
+	}
+
+	return agent, nil
+}
+
+func generateCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, config.GenerateKeys...)
+	prompt := bindPromptFlags(fs)
+	specFile := fs.String("spec", "", "Prompt spec YAML with the template, parameters, context files and prompt")
+	addons := fs.String("addons", "", "Comma separated add-ons to mix into the template (e.g. docker,postgres)")
+	params := map[string]string{}
+	fs.Func("param", "Template parameter as key=value (repeatable)", func(v string) error {
+		key, value, err := agents.ParseParam(v)
+		if err != nil {
+			return err
+		}
+		params[key] = value
+		return nil
+	})
+	listTemplates := fs.Bool("list-templates", false, "List available templates (deprecated: use maker templates list)")
+	listLanguages := fs.Bool("list-lenguages", false, "List supported programming languages (deprecated: use maker languages)")
+	output := bindOutputFlags(fs)
+
+	return func(args []string) int {
+		report, code := output.reporter(fs)
+		if report == nil {
+			return code
+		}
+
+		resolved, err := loader.Load()
+		if err != nil {
+			return fail(err)
+		}
+		cfg := resolved.Config
+
+		if err := report.setLogger(cfg); err != nil {
+			return fail(err)
+		}
+		stopTracing, err := startTracing(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		defer stopTracing()
+
+		if *listTemplates || *listLanguages {
+			catalog, err := loadCatalog(cfg)
+			if err != nil {
+				return fail(err)
+			}
+			if *listTemplates {
+				printTemplates(catalog)
+			}
+			if *listLanguages {
+				printLanguages(catalog)
+			}
+			if len(args) == 0 && !prompt.given() && *specFile == "" {
+				return exitOK
+			}
+		}
+
+		spec := &agents.PromptSpec{Dir: "."}
+		if *specFile != "" {
+			if spec, err = agents.LoadPromptSpec(*specFile); err != nil {
+				return report.fail(err, cfg.OutputDir)
+			}
+			applySpec(resolved, spec)
+		}
+
+		text, err := prompt.read(args, spec.Prompt)
+		if err != nil {
+			return report.fail(err, cfg.OutputDir)
+		}
+		if text == "" {
+			return usageError(fs, "missing prompt")
+		}
+
+		var contextFiles []agents.ContextFile
+		if len(spec.Context) > 0 {
+			if contextFiles, err = agents.LoadContextFiles(spec.Dir, spec.Context); err != nil {
+				return report.fail(err, cfg.OutputDir)
+			}
+		}
+		if contextFiles, err = prompt.contextFiles(contextFiles...); err != nil {
+			return report.fail(err, cfg.OutputDir)
+		}
+
+		ctx, span := tracing.Start(context.Background(), "generation",
+			logging.TemplateKey, cfg.Template,
+			logging.ModelKey, cfg.Model,
+			logging.LanguageKey, cfg.Language)
+		defer span.End()
+
+		_, initSpan := tracing.Start(ctx, "agent.init")
+		agent, err := newAgent(ctx, cfg, cfg.OutputDir, report.callback(cfg.OutputDir))
+		if err != nil {
+			initSpan.RecordError(err)
+			initSpan.End()
+			span.RecordError(err)
+			return report.fail(err, cfg.OutputDir)
+		}
+
+		values := spec.ParamValues()
+		for name, value := range params {
+			values[name] = value
+		}
+		agent.SetParams(values)
+		agent.SetContextFiles(contextFiles)
+
+		if spec.ProjectName != "" {
+			agent.SetProjectName(spec.ProjectName)
+		}
+
+		addonNames := spec.Addons
+		if *addons != "" {
+			addonNames = strings.Split(*addons, ",")
+		}
+		if len(addonNames) > 0 {
+			if err := agent.UseAddons(addonNames...); err != nil {
+				initSpan.RecordError(err)
+				initSpan.End()
+				span.RecordError(err)
+				return report.fail(err, cfg.OutputDir)
+			}
+		}
+		initSpan.End()
+
+		agent.Start()
+
+		report.event(server.ProgressEvent{
+			Type:       "start",
+			Message:    "Starting code generation",
+			ProjectDir: cfg.OutputDir,
+		})
+
+		if err = agent.GenerateCodeContext(ctx, text); err != nil {
+			span.RecordError(err)
+			agent.Stop()
+			return report.fail(fmt.Errorf("error writing code: %w", err), cfg.OutputDir)
+		}
+
+		time.Sleep(1 * time.Second)
+		agent.Stop()
+
+		report.usage(cfg.OutputDir, cfg.Model, agent.Usage())
+		report.event(server.ProgressEvent{
+			Type:       "complete",
+			Message:    "Finished writing project to " + cfg.OutputDir,
+			ProjectDir: cfg.OutputDir,
+		})
+
+		return exitOK
+	}
+}
+
+func editCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, config.EditKeys...)
+	prompt := bindPromptFlags(fs)
+	dir := fs.String("dir", ".", "Directory of the project to edit")
+	output := bindOutputFlags(fs)
+
+	return func(args []string) int {
+		report, code := output.reporter(fs)
+		if report == nil {
+			return code
+		}
+
+		instruction, err := prompt.read(args)
+		if err != nil {
+			return fail(err)
+		}
+		if instruction == "" {
+			return usageError(fs, "missing instruction")
+		}
+
+		contextFiles, err := prompt.contextFiles()
+		if err != nil {
+			return report.fail(err, *dir)
+		}
+
+		if info, err := os.Stat(*dir); err != nil || !info.IsDir() {
+			return report.fail(fmt.Errorf("%s is not a directory", *dir), *dir)
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return report.fail(err, *dir)
+		}
+		if err := report.setLogger(cfg); err != nil {
+			return report.fail(err, *dir)
+		}
+		stopTracing, err := startTracing(cfg)
+		if err != nil {
+			return report.fail(err, *dir)
+		}
+		defer stopTracing()
+
+		ctx, span := tracing.Start(context.Background(), "edit", logging.ModelKey, cfg.Model)
+		defer span.End()
+
+		agent, err := newAgent(ctx, cfg, *dir, report.callback(*dir))
+		if err != nil {
+			return report.fail(err, *dir)
+		}
+
+		agent.SetContextFiles(contextFiles)
+		agent.Start()
+
+		report.event(server.ProgressEvent{
+			Type:       "start",
+			Message:    "Starting code edit",
+			ProjectDir: *dir,
+		})
+
+		if err = agent.EditCodeContext(ctx, instruction); err != nil {
+			span.RecordError(err)
+			agent.Stop()
+			return report.fail(fmt.Errorf("error editing code: %w", err), *dir)
+		}
+
+		time.Sleep(1 * time.Second)
+		agent.Stop()
+
+		files := agent.WrittenFiles()
+		report.usage(*dir, cfg.Model, agent.Usage())
+		report.event(server.ProgressEvent{
+			Type:       "complete",
+			Message:    fmt.Sprintf("Updated %d files in %s", len(files), *dir),
+			ProjectDir: *dir,
+		})
+		for _, file := range files {
+			report.print("  %s\n", file)
+		}
+
+		return exitOK
+	}
+}
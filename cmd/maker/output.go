@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/agents/server"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+// Formats of -output-format.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputOptions select how a command reports its progress.
+type outputOptions struct {
+	format  string
+	quiet   bool
+	verbose bool
+}
+
+func bindOutputFlags(fs *flag.FlagSet) *outputOptions {
+	opts := &outputOptions{}
+
+	fs.StringVar(&opts.format, "output-format", outputText, "Progress output: text, or json for newline-delimited events on stdout")
+	fs.BoolVar(&opts.quiet, "quiet", false, "Only report errors")
+	fs.BoolVar(&opts.verbose, "verbose", false, "Also report every file and show the logs on stderr")
+
+	return opts
+}
+
+// reporter writes the progress events of a command: as JSON lines on
+// stdout, or as text with errors and warnings on stderr. The logs go to
+// stderr with -verbose and are discarded otherwise.
+type reporter struct {
+	opts outputOptions
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+func (o *outputOptions) reporter(fs *flag.FlagSet) (*reporter, int) {
+	if o.format != outputText && o.format != outputJSON {
+		return nil, usageError(fs, "unknown output format %q, expected text or json", o.format)
+	}
+	if o.quiet && o.verbose {
+		return nil, usageError(fs, "-quiet and -verbose cannot be used together")
+	}
+
+	return &reporter{opts: *o, enc: json.NewEncoder(os.Stdout)}, exitOK
+}
+
+// setLogger makes the logger configured by cfg the default one, discarding
+// every record unless -verbose is set.
+func (r *reporter) setLogger(cfg *config.Config) error {
+	logger, err := newLogger(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !r.opts.verbose {
+		logger = logging.Discard()
+	}
+	slog.SetDefault(logger)
+
+	return nil
+}
+
+// newLogger returns a logger writing to stderr as the log settings of cfg
+// say.
+func newLogger(cfg *config.Config) (*slog.Logger, error) {
+	return logging.New(os.Stderr, logging.Options{
+		Format: cfg.Log.Format,
+		Level:  cfg.Log.Level,
+		Redact: cfg.Log.Redact,
+	})
+}
+
+// startTracing makes a tracer exporting spans as the trace settings of cfg
+// say the default one. stop closes the exporter.
+func startTracing(cfg *config.Config) (stop func(), err error) {
+	exporter, err := tracing.OpenExporter(cfg.Trace.Exporter, cfg.Trace.File)
+	if err != nil {
+		return nil, err
+	}
+
+	tracing.SetDefault(tracing.NewTracer(exporter))
+
+	return func() {
+		if exporter != nil {
+			exporter.Close()
+		}
+	}, nil
+}
+
+func (r *reporter) json() bool {
+	return r.opts.format == outputJSON
+}
+
+// text reports whether plain text output, like tables, is wanted.
+func (r *reporter) text() bool {
+	return !r.json() && !r.opts.quiet
+}
+
+func (r *reporter) event(e server.ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.json() {
+		if err := r.enc.Encode(e); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		return
+	}
+
+	switch e.Type {
+	case "error":
+		fmt.Fprintln(os.Stderr, "error:", e.Error)
+	case "warning":
+		if !r.opts.quiet {
+			fmt.Fprintln(os.Stderr, "warning:", withFile(e.Message, e.File))
+		}
+	case "file":
+		if r.opts.verbose {
+			fmt.Println("  " + withFile(e.Message, e.File))
+		}
+	default:
+		if !r.opts.quiet {
+			fmt.Println(e.Message)
+		}
+	}
+}
+
+// print writes text output, left out in JSON and quiet modes.
+func (r *reporter) print(format string, args ...interface{}) {
+	if !r.text() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Printf(format, args...)
+}
+
+// fail reports err as an error event and returns the failure exit code.
+func (r *reporter) fail(err error, projectDir string) int {
+	r.event(server.ProgressEvent{Type: "error", Error: err.Error(), ProjectDir: projectDir})
+
+	return exitFailure
+}
+
+// callback turns the progress of an agent writing to projectDir into
+// events.
+func (r *reporter) callback(projectDir string) agents.ProgressCallBack {
+	return func(eventType, message, file string) {
+		r.event(server.ProgressEvent{
+			Type:       eventType,
+			Message:    message,
+			File:       file,
+			ProjectDir: projectDir,
+		})
+	}
+}
+
+// usage reports the tokens used by a generation in projectDir.
+func (r *reporter) usage(projectDir, model string, usage agents.Usage) {
+	r.event(server.ProgressEvent{
+		Type:       "usage",
+		Message:    fmt.Sprintf("Used %d tokens (~$%.4f)", usage.TotalTokens, agents.EstimateCost(model, usage)),
+		ProjectDir: projectDir,
+		Usage:      &usage,
+	})
+}
+
+func withFile(message, file string) string {
+	if file == "" {
+		return message
+	}
+
+	return message + ": " + file
+}
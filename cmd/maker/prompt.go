@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/config"
+)
+
+// stdinArg stands for standard input in place of a prompt or a prompt file.
+const stdinArg = "-"
+
+// promptOptions are the flags giving the prompt of a command and the files
+// sent with it.
+type promptOptions struct {
+	promptFile string
+	context    []string
+}
+
+func bindPromptFlags(fs *flag.FlagSet) *promptOptions {
+	opts := &promptOptions{}
+
+	fs.StringVar(&opts.promptFile, "prompt-file", "", "Read the prompt from a file, e.g. a Markdown document (- reads stdin)")
+	fs.Func("context", "File, directory or glob sent with the prompt as context (repeatable)", func(v string) error {
+		opts.context = append(opts.context, v)
+		return nil
+	})
+
+	return opts
+}
+
+// given reports whether the prompt comes from a flag rather than from the
+// arguments.
+func (o *promptOptions) given() bool {
+	return o.promptFile != ""
+}
+
+// read returns the prompt: the leading parts, e.g. the prompt of a spec,
+// the prompt file and the arguments, separated by blank lines. A single
+// "-" argument reads standard input.
+func (o *promptOptions) read(args []string, parts ...string) (string, error) {
+	stdinUsed := false
+
+	readStdin := func() (string, error) {
+		if stdinUsed {
+			return "", errors.New("standard input can only be read once")
+		}
+		stdinUsed = true
+
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("reading standard input: %w", err)
+		}
+
+		return string(data), nil
+	}
+
+	if o.promptFile == stdinArg {
+		text, err := readStdin()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, text)
+	} else if o.promptFile != "" {
+		data, err := os.ReadFile(o.promptFile)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, string(data))
+	}
+
+	if len(args) == 1 && args[0] == stdinArg {
+		text, err := readStdin()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, text)
+	} else if len(args) > 0 {
+		parts = append(parts, strings.Join(args, " "))
+	}
+
+	var prompt []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			prompt = append(prompt, part)
+		}
+	}
+
+	return strings.Join(prompt, "\n\n"), nil
+}
+
+// contextFiles reads the files of the -context flags, relative to the
+// working directory, after those given in extra.
+func (o *promptOptions) contextFiles(extra ...agents.ContextFile) ([]agents.ContextFile, error) {
+	if len(o.context) == 0 {
+		return extra, nil
+	}
+
+	files, err := agents.LoadContextFiles(".", o.context)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(extra, files...), nil
+}
+
+// applySpec sets the settings of spec in the resolved configuration,
+// except those given as flags, which take precedence over the spec.
+func applySpec(resolved *config.Resolved, spec *agents.PromptSpec) {
+	set := func(key string, field *string, value string) {
+		if value == "" || strings.HasPrefix(resolved.Sources[key], "flag ") {
+			return
+		}
+
+		*field = value
+	}
+
+	cfg := resolved.Config
+	set("template", &cfg.Template, spec.Template)
+	set("language", &cfg.Language, spec.Language)
+	set("base_package", &cfg.BasePackage, spec.BasePackage)
+	set("model", &cfg.Model, spec.Model)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/lFer17/codebase-maker/internal/agents/server"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+func serveCommand(fs *flag.FlagSet) func(args []string) int {
+	loader := config.NewLoader()
+	loader.BindFlags(fs, config.ServerKeys...)
+
+	return func(args []string) int {
+		if len(args) > 0 {
+			return usageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		cfg, err := loadConfig(loader)
+		if err != nil {
+			return fail(err)
+		}
+
+		if cfg.APIKey == "" {
+			return fail(errNoAPIKey)
+		}
+
+		logger, err := newLogger(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		slog.SetDefault(logger)
+
+		stopTracing, err := startTracing(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		defer stopTracing()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		opts := server.OptionsFromConfig(cfg)
+		opts.Logger = logger
+		opts.Tracer = tracing.Default()
+		if opts.Cache, err = newCache(cfg); err != nil {
+			return fail(err)
+		}
+
+		if err := server.Run(ctx, opts); err != nil {
+			return fail(err)
+		}
+
+		return exitOK
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+)
+
+func validateTemplatesCommand(fs *flag.FlagSet) func(args []string) int {
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON")
+	strict := fs.Bool("strict", false, "Treat warnings as errors")
+
+	return func(args []string) int {
+		dirs := args
+		if len(dirs) == 0 {
+			for _, dir := range agents.DefaultTemplatePaths() {
+				if info, err := os.Stat(dir); err == nil && info.IsDir() {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+
+		report, err := agents.ValidateTemplates(dirs...)
+		if err != nil {
+			return fail(err)
+		}
+
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		} else {
+			for _, issue := range report.Issues {
+				fmt.Println(issue)
+			}
+
+			errors := report.Errors()
+			fmt.Printf("%d templates, %d add-ons, %d prompt templates: %d errors, %d warnings\n",
+				report.Templates, report.Addons, report.Prompts, errors, len(report.Issues)-errors)
+		}
+
+		if report.Errors() > 0 || (*strict && len(report.Issues) > 0) {
+			return exitFailure
+		}
+
+		return exitOK
+	}
+}
+
+func captureTemplateCommand(fs *flag.FlagSet) func(args []string) int {
+	name := fs.String("name", "", "Template name (default: the project name)")
+	description := fs.String("description", "", "Template description")
+	out := fs.String("out", "", "Directory to write the template package to (default: ./templates/<name>)")
+	pkg := fs.String("package", "", "Module path or package name to replace with {{.Package}} (default: read from go.mod)")
+	projectName := fs.String("project-name", "", "Name to replace with {{.ProjectName}} (default: detected)")
+	dryRun := fs.Bool("dry-run", false, "Print what would be captured without writing it")
+
+	return func(args []string) int {
+		if len(args) != 1 {
+			return usageError(fs, "expected one project directory")
+		}
+
+		captured, err := agents.CaptureTemplate(args[0], agents.CaptureOptions{
+			Name:        *name,
+			Description: *description,
+			Package:     *pkg,
+			ProjectName: *projectName,
+		})
+		if err != nil {
+			return fail(err)
+		}
+
+		target := *out
+		if target == "" {
+			target = filepath.Join("templates", captured.Template.Name)
+		}
+
+		fmt.Printf("Template %s (%s), %d files\n", captured.Template.Name, captured.Template.Language, len(captured.Files))
+		for _, note := range captured.Notes {
+			fmt.Println("  " + note)
+		}
+
+		if *dryRun {
+			for _, file := range captured.Files {
+				fmt.Println("  files/" + file.Path)
+			}
+			fmt.Printf("\nDrafted prompt:\n%s\n", captured.Template.Prompt)
+			return exitOK
+		}
+
+		if err := captured.Write(target); err != nil {
+			return fail(err)
+		}
+
+		fmt.Printf("Wrote %s; review the drafted prompt in template.yaml and run: maker templates validate %s\n", target, filepath.Dir(target))
+
+		return exitOK
+	}
+}
+
+func fetchTemplatesCommand(fs *flag.FlagSet) func(args []string) int {
+	name := fs.String("name", "", "Name of the source in the cache (default: derived from the URL)")
+	ref := fs.String("ref", "", "Branch or tag of git sources (or append #ref to the URL)")
+	update := fs.Bool("update", false, "Fetch every source in the cache again")
+
+	return func(args []string) int {
+		if (*update && len(args) != 0) || (!*update && len(args) != 1) {
+			return usageError(fs, "expected one source, or -update without arguments")
+		}
+
+		fetcher, err := agents.NewFetcher()
+		if err != nil {
+			return fail(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		var requests []agents.TemplateSource
+		if *update {
+			sources, err := fetcher.Sources()
+			if err != nil {
+				return fail(err)
+			}
+			for _, source := range sources {
+				if source.URL == "" {
+					fmt.Fprintf(os.Stderr, "skipping %s: unknown origin\n", source.Name)
+					continue
+				}
+				requests = append(requests, source)
+			}
+		} else {
+			requests = append(requests, agents.TemplateSource{URL: args[0], Name: *name, Ref: *ref})
+		}
+
+		code := exitOK
+
+		for _, request := range requests {
+			source, err := fetcher.Fetch(ctx, request.URL, request.Name, request.Ref)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				code = exitFailure
+				continue
+			}
+
+			fmt.Printf("Fetched %s into %s\n", source.URL, source.Dir)
+
+			report, err := agents.ValidateTemplates(source.Dir)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				code = exitFailure
+				continue
+			}
+
+			for _, issue := range report.Issues {
+				fmt.Println("  " + issue.String())
+			}
+			if report.Errors() > 0 {
+				fmt.Fprintf(os.Stderr, "%s has %d invalid templates, they will be skipped when loading\n", source.Name, report.Errors())
+				code = exitFailure
+			}
+		}
+
+		return code
+	}
+}
+
+func templateSourcesCommand(fs *flag.FlagSet) func(args []string) int {
+	remove := fs.String("remove", "", "Remove a fetched source from the cache")
+
+	return func(args []string) int {
+		fetcher, err := agents.NewFetcher()
+		if err != nil {
+			return fail(err)
+		}
+
+		if *remove != "" {
+			if err := fetcher.Remove(*remove); err != nil {
+				return fail(err)
+			}
+			fmt.Println("Removed", *remove)
+			return exitOK
+		}
+
+		printTemplatePaths()
+
+		sources, err := fetcher.Sources()
+		if err != nil {
+			return fail(err)
+		}
+
+		fmt.Println("Fetched sources:")
+		for _, source := range sources {
+			origin := source.URL
+			if source.Ref != "" {
+				origin += "#" + source.Ref
+			}
+			fmt.Printf("  %s: %s (%s)\n", source.Name, origin, source.FetchedAt.Local().Format("2006-01-02 15:04"))
+		}
+
+		return exitOK
+	}
+}
+
+func printTemplatePaths() {
+	fmt.Println("Template search paths (later ones override earlier ones):")
+	fmt.Println("  embedded")
+	for _, dir := range agents.DefaultTemplatePaths() {
+		if _, err := os.Stat(dir); err != nil {
+			fmt.Printf("  %s (missing)\n", dir)
+		} else {
+			fmt.Printf("  %s\n", dir)
+		}
+	}
+}
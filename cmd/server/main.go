@@ -1,40 +1,84 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/joho/godotenv"
 	"github.com/lFer17/codebase-maker/internal/agents/server"
+	"github.com/lFer17/codebase-maker/internal/cache"
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
 )
 
 func main() {
-	openApikey := flag.String("openai-key", "", "OpenAI API key")
-	outputDir := flag.String("output-dir", "./output", "Output directory for generated files")
-	port := flag.String("port", "3000", "Server port")
+	os.Exit(run())
+}
+
+// run starts the server and returns the exit code. Exiting from main, after
+// run returns, lets the deferred calls flush the trace exporter.
+func run() int {
+	loader := config.NewLoader()
+	loader.BindFlags(flag.CommandLine, config.ServerKeys...)
 
 	flag.Parse()
 
-	if *openApikey == "" {
-		err := godotenv.Load()
-		*openApikey = os.Getenv("OPENAI_KEY")
-		if *openApikey == "" && err != nil {
-			fmt.Println("Please Provide OpenAi Api key using -openai-key flag or set OPENAI_KEY environment variable")
-			os.Exit(1)
-		}
+	resolved, err := loader.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg := resolved.Config
+
+	logger, err := logging.New(os.Stderr, logging.Options{
+		Format: cfg.Log.Format,
+		Level:  cfg.Log.Level,
+		Redact: cfg.Log.Redact,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	slog.SetDefault(logger)
+
+	exporter, err := tracing.OpenExporter(cfg.Trace.Exporter, cfg.Trace.File)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if exporter != nil {
+		defer exporter.Close()
 	}
 
-	srv := server.NewServer(*openApikey, *outputDir)
+	if cfg.APIKey == "" {
+		fmt.Println("Please Provide OpenAi Api key using -openai-key flag, the api_key setting or the OPENAI_KEY environment variable")
+		return 1
+	}
 
-	http.Handle("/", http.FileServer(http.Dir("web/static")))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	http.HandleFunc("/api/generate", srv.HandleGenerate)
-	http.HandleFunc("/download/", srv.HandleDownload)
+	opts := server.OptionsFromConfig(cfg)
+	opts.Logger = logger
+	opts.Tracer = tracing.NewTracer(exporter)
 
-	log.Printf("Server starting on http://localhost:%s", *port)
-	log.Fatal(http.ListenAndServe(":"+*port, nil))
+	if !cfg.Cache.Disabled {
+		if opts.Cache, err = cache.New(cfg.Cache.Dir, cfg.Cache.TTL, int64(cfg.Cache.MaxSize)<<20); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	if err := server.Run(ctx, opts); err != nil {
+		logger.Error("Server failed", logging.ErrorKey, err)
+		return 1
+	}
 
+	return 0
 }
@@ -4,33 +4,81 @@ import (
 	"bytes"
 	"context"
 	"embed"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"path"
 	"path/filepath"
-	"strings"
+	"sort"
 	"sync"
 	"text/template"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
 )
 
 // GO embed templates
 //
-//go:embed templates/*
+//go:embed all:templates
 var templatesFS embed.FS
 
 type fileTask struct {
 	Path    string
 	Content string
+	Mode    os.FileMode
+	// ctx carries the span the write is traced under, if any.
+	ctx context.Context
 }
 
 type ProjectTemplate struct {
-	Name        string            `json:"name"`
-	Description string            `json:"description"`
-	Language    string            `json:"language"`
-	Prompt      string            `json:"prompt"`
-	Files       map[string]string `json:"files"`
+	Name        string              `json:"name" yaml:"name"`
+	Description string              `json:"description" yaml:"description"`
+	Language    string              `json:"language" yaml:"language"`
+	Extends     string              `json:"extends,omitempty" yaml:"extends,omitempty"`
+	Mixins      []string            `json:"mixins,omitempty" yaml:"mixins,omitempty"`
+	Parameters  []TemplateParameter `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Prompt      string              `json:"prompt" yaml:"prompt"`
+	Files       map[string]string   `json:"files" yaml:"files,omitempty"`
+	// Executable lists the package files, as globs or directories, written
+	// with the executable bit.
+	Executable []string `json:"executable,omitempty" yaml:"executable,omitempty"`
+	// Conditions maps package files, as globs or directories, to template
+	// expressions deciding whether they are included.
+	Conditions map[string]string `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	// SystemPrompt replaces the prompt template of the language. It is
+	// rendered like prompt templates, so it can use {{.ExtraPrompt}}.
+	SystemPrompt string `json:"system_prompt,omitempty" yaml:"system_prompt,omitempty"`
+	// Examples are few-shot exchanges sent before the user prompt.
+	Examples []TemplateExample `json:"examples,omitempty" yaml:"examples,omitempty"`
+	// References lists files, as globs or directories relative to the
+	// template, sent to the model as context.
+	References []string `json:"references,omitempty" yaml:"references,omitempty"`
+	// Sampling are the default sampling parameters of the template. Flags
+	// and requests override them one by one.
+	Sampling SamplingParams `json:"sampling,omitempty" yaml:"sampling,omitempty"`
+
+	trees      []templateTree
+	references []templateFile
+	// filesAfter places the inline files among the package trees of the
+	// layers merged into the template: a file goes on top of that many trees
+	// and under the rest. Files missing from it go on top of all of them.
+	filesAfter map[string]int
+}
+
+// fileLayer returns how many package trees the inline file p goes on top of.
+func (tmpl ProjectTemplate) fileLayer(p string) int {
+	if n, ok := tmpl.filesAfter[p]; ok {
+		return n
+	}
+
+	return len(tmpl.trees)
+}
+
+// templateData is what file, path and condition templates are rendered with.
+type templateData struct {
+	Package     string
+	ProjectName string
+	Params      map[string]interface{}
 }
 
 type PromptTemplate struct {
@@ -40,29 +88,57 @@ type PromptTemplate struct {
 }
 
 type Agent struct {
-	openAi           *OpenAPI
-	outputDir        string
-	basePackage      string
-	taskQueue        chan fileTask
-	wg               sync.WaitGroup
-	workerCount      int
-	ctx              context.Context
-	cancel           context.CancelFunc
-	fileWriterMutex  sync.Mutex
-	filesWritten     map[string]bool
-	selectedTmpl     string
-	language         string
-	templates        map[string]ProjectTemplate
-	promptsTmpl      map[string]PromptTemplate
-	progressCallBack ProgressCallBack
+	openAi             *OpenAPI
+	outputDir          string
+	basePackage        string
+	taskQueue          chan fileTask
+	wg                 sync.WaitGroup
+	workerCount        int
+	ctx                context.Context
+	cancel             context.CancelFunc
+	fileWriterMutex    sync.Mutex
+	filesWritten       map[string]bool
+	selectedTmpl       string
+	language           string
+	catalogMutex       sync.RWMutex
+	templatePaths      []string
+	extraTemplatePaths []string
+	templates          map[string]ProjectTemplate
+	addons             map[string]ProjectTemplate
+	selectedAddons     []string
+	projectName        string
+	paramValues        map[string]string
+	params             map[string]interface{}
+	contextFiles       []ContextFile
+	promptsTmpl        map[string]PromptTemplate
+	progressCallBack   ProgressCallBack
+	logger             *slog.Logger
+	usageMutex         sync.Mutex
+	usage              Usage
 }
 
 var (
 	Languages = []string{"Go", "Python", "JavaScript", "java"}
+
+	// ErrUpstream wraps every error returned by the model provider.
+	ErrUpstream = errors.New("error queyring OpenAI")
 )
 
 type ProgressCallBack func(eventType, message, file string)
 
+type AgentConfig struct {
+	OpenAI      *OpenAPI
+	OutputDir   string
+	BasePackage string
+	Template    string
+	Language    string
+	WorkerCount int
+	CallBack    ProgressCallBack
+	// Logger defaults to slog.Default(). Attributes added to it, like a
+	// session ID, are kept in every record of the agent.
+	Logger *slog.Logger
+}
+
 func NewAgent(ctx context.Context,
 	openAI *OpenAPI,
 	outputDir string,
@@ -71,28 +147,16 @@ func NewAgent(ctx context.Context,
 	language string,
 	workerCount int,
 ) (*Agent, error) {
-	ctx, cancel := context.WithCancel(ctx)
-
-	agent := &Agent{
-		openAi:       openAI,
-		outputDir:    outputDir,
-		basePackage:  basePackage,
-		taskQueue:    make(chan fileTask, 100),
-		workerCount:  workerCount,
-		ctx:          ctx,
-		cancel:       cancel,
-		filesWritten: make(map[string]bool),
-		selectedTmpl: templateName,
-		language:     language,
-	}
-	if err := agent.loadTemplates(); err != nil {
-		return nil, err
-	}
-
-	agent.loadPromptTemplates()
-
-	return agent, nil
+	return NewAgentWithConfig(ctx, AgentConfig{
+		OpenAI:      openAI,
+		OutputDir:   outputDir,
+		BasePackage: basePackage,
+		Template:    templateName,
+		Language:    language,
+		WorkerCount: workerCount,
+	})
 }
+
 func NewAgentWithCallback(ctx context.Context,
 	openAI *OpenAPI,
 	outputDir string,
@@ -101,19 +165,54 @@ func NewAgentWithCallback(ctx context.Context,
 	language string,
 	workerCount int,
 	callBack ProgressCallBack) (*Agent, error) {
-	agent, err := NewAgent(ctx, openAI, outputDir, basePackage, templateName, language, workerCount)
+	return NewAgentWithConfig(ctx, AgentConfig{
+		OpenAI:      openAI,
+		OutputDir:   outputDir,
+		BasePackage: basePackage,
+		Template:    templateName,
+		Language:    language,
+		WorkerCount: workerCount,
+		CallBack:    callBack,
+	})
+}
 
-	if err != nil {
-		return nil, err
+func NewAgentWithConfig(ctx context.Context, cfg AgentConfig) (*Agent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
 	}
 
-	agent.progressCallBack = callBack
+	agent := &Agent{
+		openAi:           cfg.OpenAI,
+		outputDir:        cfg.OutputDir,
+		basePackage:      cfg.BasePackage,
+		taskQueue:        make(chan fileTask, 100),
+		workerCount:      cfg.WorkerCount,
+		ctx:              ctx,
+		cancel:           cancel,
+		filesWritten:     make(map[string]bool),
+		selectedTmpl:     cfg.Template,
+		language:         cfg.Language,
+		projectName:      filepath.Base(cfg.OutputDir),
+		progressCallBack: cfg.CallBack,
+		logger:           logger,
+	}
+	if err := agent.ReloadTemplates(); err != nil {
+		return nil, err
+	}
 
 	return agent, nil
 }
 
+// Logger returns the logger of the agent.
+func (a *Agent) Logger() *slog.Logger {
+	return a.logger
+}
+
 func (a *Agent) Start() {
-	log.Printf("Starting %d workers...\n", a.workerCount)
+	a.logger.Debug("Starting workers", "count", a.workerCount)
 	for i := 0; i < a.workerCount; i++ {
 		a.wg.Add(1)
 		go a.worker(i)
@@ -122,13 +221,15 @@ func (a *Agent) Start() {
 
 func (a *Agent) worker(id int) {
 	defer a.wg.Done()
-	log.Printf("Worker %d started\n", id)
+
+	logger := a.logger.With(logging.WorkerKey, id)
+	logger.Debug("Worker started")
 
 	for {
 		select {
 		case task, ok := <-a.taskQueue:
 			if !ok {
-				log.Printf("Worker %d: Task channel closed, exiting\n", id)
+				logger.Debug("Task channel closed, worker exiting")
 				return
 			}
 
@@ -138,7 +239,7 @@ func (a *Agent) worker(id int) {
 
 			a.fileWriterMutex.Lock()
 			if a.filesWritten[task.Path] {
-				log.Printf("Worker %d: File %s already written, skipping\n", id, task.Path)
+				logger.Debug("File already written, skipping", logging.FileKey, task.Path)
 				a.fileWriterMutex.Unlock()
 				continue
 			}
@@ -146,22 +247,39 @@ func (a *Agent) worker(id int) {
 			a.filesWritten[task.Path] = true
 			a.fileWriterMutex.Unlock()
 
+			ctx := task.ctx
+			if ctx == nil {
+				ctx = a.ctx
+			}
+			_, span := tracing.Start(ctx, "file.write", logging.FileKey, task.Path, "bytes", len(task.Content), logging.WorkerKey, id)
+
 			err := a.writeFile(task)
+			span.RecordError(err)
+			span.End()
 
 			if err != nil {
-				log.Printf("Worker %d: Error writing file %s: %v\n", id, task.Path, err)
+				logger.Error("Error writing file", logging.FileKey, task.Path, logging.ErrorKey, err)
+				if a.progressCallBack != nil {
+					a.progressCallBack("warning", "Failed to write file: "+err.Error(), task.Path)
+				}
 			} else {
-				log.Printf("Worker %d: Successfully wrote file %s\n", id, task.Path)
+				logger.Debug("Wrote file", logging.FileKey, task.Path)
 			}
 
 		case <-a.ctx.Done():
-			log.Printf("Worker %d: Context cancelled, exiting\n", id)
+			logger.Debug("Context cancelled, worker exiting")
 			return
 		}
 	}
 }
 
 func (a *Agent) writeFile(task fileTask) error {
+	// paths come from the model and from templates, which may be fetched
+	// from anywhere, so they must stay inside the output directory
+	if !filepath.IsLocal(filepath.FromSlash(task.Path)) {
+		return fmt.Errorf("refusing to write %s: path is outside the project", task.Path)
+	}
+
 	fullPath := filepath.Join(a.outputDir, task.Path)
 
 	dir := filepath.Dir(fullPath)
@@ -171,14 +289,21 @@ func (a *Agent) writeFile(task fileTask) error {
 		return fmt.Errorf("failed to create directories for %s: %w", fullPath, err)
 	}
 
-	err := os.WriteFile(fullPath, []byte(task.Content), 0644)
+	mode := task.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+
+	err := os.WriteFile(fullPath, []byte(task.Content), mode)
+	if err == nil {
+		// WriteFile keeps the mode of files that already exist
+		err = os.Chmod(fullPath, mode)
+	}
 
 	if err != nil {
 		return fmt.Errorf("failed to write file %s: %w", fullPath, err)
 	}
 
-	log.Printf("Writing file to %s\n", fullPath)
-
 	return nil
 }
 
@@ -194,7 +319,7 @@ func (a *Agent) SendFileTask(path string, content string) {
 }
 
 func (a *Agent) Stop() {
-	log.Println("Stopping agent...")
+	a.logger.Debug("Stopping agent")
 	close(a.taskQueue)
 	a.cancel()
 	a.wg.Wait()
@@ -204,74 +329,23 @@ func (a *Agent) loadTemplates() error {
 
 	a.templates = make(map[string]ProjectTemplate)
 
-	loaded := 0
-
-	log.Println("Loading templates from embedded filesystem...")
-	entries, err := templatesFS.ReadDir("templates")
-	if err != nil {
+	a.logger.Debug("Loading templates from embedded filesystem")
+	if _, err := templatesFS.ReadDir("templates"); err != nil {
 		return fmt.Errorf("reading template directory: %w", err)
 	}
 
-	for _, file := range entries {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-			continue
-		}
-
-		data, err := templatesFS.ReadFile(path.Join("templates", file.Name()))
-		if err != nil {
-			log.Printf("Warning: Could not read template file %s: %v", file.Name(), err)
-			continue
-		}
-
-		var tmpl ProjectTemplate
-		if err := json.Unmarshal(data, &tmpl); err != nil {
-			log.Printf("Warning: Invalid template format in %s: %v", file.Name(), err)
-			continue
-		}
-
-		a.templates[tmpl.Name] = tmpl
-		log.Printf("Loaded template: %s - %s (%s)", tmpl.Name, tmpl.Description, tmpl.Language)
-		loaded++
-	}
+	loaded := a.loadTemplatesFrom(templatesFS, "templates", "embedded")
 
 	// load user custom templates
-	userCustomTemplatePath := "./templates"
-	if _, err := os.Stat(userCustomTemplatePath); !os.IsNotExist(err) {
-		dirs, err := os.ReadDir(userCustomTemplatePath)
-		if err != nil {
-			fmt.Printf("reading custom template directory: %v", err)
-		} else {
-			for _, file := range dirs {
-				if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-					continue
-				}
-
-				data, err := templatesFS.ReadFile(filepath.Join(userCustomTemplatePath, file.Name()))
-				if err != nil {
-					log.Printf("Warning: Could not read template file %s: %v", file.Name(), err)
-					continue
-				}
-
-				var tmpl ProjectTemplate
-				if err := json.Unmarshal(data, &tmpl); err != nil {
-					log.Printf("Warning: Invalid template format in %s: %v", file.Name(), err)
-					continue
-				}
-
-				if _, exists := a.templates[tmpl.Name]; exists {
-					log.Printf("User template '%s' overrides embedded template with same name", tmpl.Name)
-				}
-
-				a.templates[tmpl.Name] = tmpl
-				log.Printf("Loaded template: %s - %s (%s)", tmpl.Name, tmpl.Description, tmpl.Language)
-				loaded++
-			}
+	for _, dir := range a.templatePaths {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			loaded += a.loadTemplatesFrom(os.DirFS(dir), ".", dir)
 		}
 	}
 
 	if loaded == 0 {
 
-		log.Println("No templates fund., adding default templates")
+		a.logger.Warn("No templates found, adding default templates")
 
 		for _, lang := range Languages {
 			a.templates[lang+"-default"] = ProjectTemplate{
@@ -296,7 +370,7 @@ func (a *Agent) loadTemplates() error {
 		loaded++
 	}
 
-	log.Printf("Loaded %d templates\n", loaded)
+	a.logger.Info("Loaded templates", "count", loaded)
 
 	return nil
 }
@@ -308,43 +382,40 @@ func (a *Agent) loadPromptTemplates() {
 		a.promptsTmpl[p.Language] = p
 	}
 
-	customPromptPath := "./templates/prompts"
+	for _, dir := range a.templatePaths {
+		customPromptPath := filepath.Join(dir, "prompts")
 
-	if _, err := os.Stat(customPromptPath); !os.IsNotExist(err) {
-		files, err := os.ReadDir(customPromptPath)
-		if err == nil {
-
-			for _, file := range files {
-				if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-					continue
-				}
-				data, err := os.ReadFile(filepath.Join(customPromptPath, file.Name()))
-
-				if err != nil {
-					log.Printf("Warning: could not read prompt template file %s:%v", file.Name(), err)
-					continue
-				}
-
-				var tmpl PromptTemplate
+		entries, err := readPromptDir(os.DirFS(customPromptPath), ".", customPromptPath, false)
+		if err != nil {
+			continue
+		}
 
-				if err := json.Unmarshal(data, &tmpl); err != nil {
-					log.Printf("Warning: Invalid Prompt Template format in %s:%v", file.Name(), err)
-					continue
-				}
+		for _, entry := range entries {
+			if entry.Err != nil {
+				a.logger.Warn("Invalid prompt template format", logging.SourceKey, entry.Source, logging.ErrorKey, entry.Err)
+				continue
+			}
 
-				if _, exists := a.promptsTmpl[tmpl.Language]; exists {
-					log.Printf("User prompt template '%s' overrides embedded template with same name", tmpl.Language)
-				}
+			tmpl := entry.Prompt
 
-				a.promptsTmpl[tmpl.Language] = tmpl
+			if _, exists := a.promptsTmpl[tmpl.Language]; exists {
+				a.logger.Info("User prompt template overrides embedded template with same name", logging.LanguageKey, tmpl.Language)
 			}
 
+			a.promptsTmpl[tmpl.Language] = tmpl
 		}
-
 	}
 
 }
 
+func (a *Agent) templateData() templateData {
+	return templateData{
+		Package:     a.basePackage,
+		ProjectName: a.projectName,
+		Params:      a.params,
+	}
+}
+
 func (a *Agent) processTemplate(content string) (string, error) {
 	tmpl, err := template.New("content").Parse(content)
 
@@ -352,15 +423,9 @@ func (a *Agent) processTemplate(content string) (string, error) {
 		return "", err
 	}
 
-	data := struct {
-		Package string
-	}{
-		Package: a.basePackage,
-	}
-
 	var buf bytes.Buffer
 
-	if err = tmpl.Execute(&buf, data); err != nil {
+	if err = tmpl.Execute(&buf, a.templateData()); err != nil {
 		return "", err
 	}
 
@@ -369,84 +434,180 @@ func (a *Agent) processTemplate(content string) (string, error) {
 }
 
 func (a *Agent) GenerateCode(prompt string) error {
-	tmpl, ok := a.templates[a.selectedTmpl]
+	return a.GenerateCodeContext(a.ctx, prompt)
+}
+
+// GenerateCodeContext is GenerateCode with a context carrying the span the
+// generation steps are traced under. The model request is canceled with
+// ctx.
+func (a *Agent) GenerateCodeContext(ctx context.Context, prompt string) error {
+	tmpl, err := a.renderTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, span := tracing.Start(ctx, "prompt.render", logging.TemplateKey, tmpl.Name)
+	messages, err := a.messages(tmpl, prompt)
+	span.SetAttributes("messages", len(messages))
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return err
+	}
+
+	// the sampling parameters of the template are defaults, those of the
+	// client come from the flags or the request
+	sampling := tmpl.Sampling.Merge(a.openAi.Sampling())
+	if err := sampling.Validate(); err != nil {
+		return fmt.Errorf("template %s: %w", tmpl.Name, err)
+	}
+
+	res, err := a.openAi.QueryMessagesWithSampling(ctx, messages, sampling)
 
-	if !ok {
-		return fmt.Errorf("template %s not found", a.selectedTmpl)
+	if err != nil {
+		return fmt.Errorf("%w:%w", ErrUpstream, err)
+	}
+
+	a.reportCached(res)
+
+	a.usageMutex.Lock()
+	a.usage.Add(res.Usage)
+	a.usageMutex.Unlock()
+
+	// handling openAI response
+
+	if err = a.parseCode(ctx, res.Choices[0].Message.Content); err != nil {
+		return fmt.Errorf("error parsing code:%w", err)
+	}
+
+	return nil
+
+}
+
+// renderTemplate resolves the selected template and queues its files.
+func (a *Agent) renderTemplate(ctx context.Context) (tmpl ProjectTemplate, err error) {
+	ctx, span := tracing.Start(ctx, "template.render", logging.TemplateKey, a.selectedTmpl)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	tmpl, err = a.ResolveTemplate(a.selectedTmpl, a.selectedAddons...)
+
+	if err != nil {
+		return tmpl, err
+	}
+
+	a.params, err = ResolveParams(tmpl.Parameters, a.paramValues)
+	if err != nil {
+		return tmpl, fmt.Errorf("template %s: %w", tmpl.Name, err)
 	}
 
 	if tmpl.Language != "" {
 		a.language = tmpl.Language
 	}
 
-	log.Printf("Generating code for instruction using template: %s (language:%s)", a.selectedTmpl, a.language)
+	a.logger.Info("Generating code", logging.TemplateKey, a.selectedTmpl, logging.LanguageKey, a.language)
+
+	files, err := renderTemplateTrees(tmpl, a.templateData())
+	if err != nil {
+		return tmpl, fmt.Errorf("template %s: %w", tmpl.Name, err)
+	}
 
 	for path, content := range tmpl.Files {
+		// a package file of a more specific layer wins over the inline
+		// files of the layers under it
+		if file, ok := files[path]; ok && file.tree >= tmpl.fileLayer(path) {
+			continue
+		}
 
 		tmplContent, err := a.processTemplate(content)
 
 		if err != nil {
-			log.Printf("Warning: proccessing template %s:%v", path, err)
+			a.logger.Warn("Template file not processed", logging.FileKey, path, logging.ErrorKey, err)
+			if a.progressCallBack != nil {
+				a.progressCallBack("warning", "Template file not processed: "+err.Error(), path)
+			}
 			tmplContent = content
 		}
+
+		file := templateFile{Path: path, Content: tmplContent}
+		if tmpl.isExecutable(path) {
+			file.Mode = 0755
+		}
+		files[path] = file
+	}
+
+	for path, file := range files {
 		if a.progressCallBack != nil {
 			a.progressCallBack("file", "Sending file queue", path)
 		}
 
 		a.taskQueue <- fileTask{
 			Path:    path,
-			Content: tmplContent,
+			Content: file.Content,
+			Mode:    file.Mode,
+			ctx:     ctx,
 		}
-		log.Printf("Added template file to queu: %s", path)
+		a.logger.Debug("Added template file to queue", logging.FileKey, path)
 	}
 
-	promptTemplate, ok := a.promptsTmpl[a.language]
+	span.SetAttributes("files", len(files))
 
-	if !ok {
-		log.Printf("No prompt template found %s, using default", a.language)
-
-		promptTemplate = a.promptsTmpl["default"]
-	}
+	return tmpl, nil
+}
 
-	promptData := struct {
-		BasePackage string
-		ExtraPrompt string
-	}{
-		BasePackage: a.basePackage,
-		ExtraPrompt: tmpl.Prompt,
+// reportCached tells the progress callback when res comes from the
+// response cache.
+func (a *Agent) reportCached(res OpenAPIResponse) {
+	if res.Cached && a.progressCallBack != nil {
+		a.progressCallBack("cache", "Reusing the cached model response", "")
 	}
+}
 
-	var buf bytes.Buffer
-
-	t, err := template.New("prompt").Parse(promptTemplate.Template)
-
-	if err != nil {
-		return fmt.Errorf("error parsing prompt template:%w", err)
-	}
+// SetProjectName sets the name available to templates as
+// {{.ProjectName}}. It defaults to the base name of the output directory.
+func (a *Agent) SetProjectName(name string) {
+	a.projectName = name
+}
 
-	if err := t.Execute(&buf, promptData); err != nil {
-		return fmt.Errorf("error executing prompt template:%w", err)
-	}
+// SetParams sets the raw values of the template parameters. They are
+// validated against the template schema when generating.
+func (a *Agent) SetParams(values map[string]string) {
+	a.paramValues = values
+}
 
-	formattedSystemPrompt := buf.String()
+// QueueDepth returns the number of file tasks waiting for a worker.
+func (a *Agent) QueueDepth() int {
+	return len(a.taskQueue)
+}
 
-	res, err := a.openAi.Query(formattedSystemPrompt, prompt)
+// WrittenFiles returns the paths handed to the workers so far, sorted.
+func (a *Agent) WrittenFiles() []string {
+	a.fileWriterMutex.Lock()
+	defer a.fileWriterMutex.Unlock()
 
-	if err != nil {
-		return fmt.Errorf("error queyring OpenAI:%w", err)
+	files := make([]string, 0, len(a.filesWritten))
+	for path := range a.filesWritten {
+		files = append(files, path)
 	}
+	sort.Strings(files)
 
-	// handling openAI response
-
-	if err = a.ParserCode(res.Choices[0].Message.Content); err != nil {
-		return fmt.Errorf("error parsing code:%w", err)
-	}
+	return files
+}
 
-	return nil
+// Usage returns the tokens consumed by the agent so far.
+func (a *Agent) Usage() Usage {
+	a.usageMutex.Lock()
+	defer a.usageMutex.Unlock()
 
+	return a.usage
 }
 
 func (a *Agent) ListTemplates() []ProjectTemplate {
+	a.catalogMutex.RLock()
+	defer a.catalogMutex.RUnlock()
+
 	templates := make([]ProjectTemplate, 0, len(a.templates))
 
 	for _, tmpl := range a.templates {
@@ -457,6 +618,9 @@ func (a *Agent) ListTemplates() []ProjectTemplate {
 }
 
 func (a *Agent) Listlanguages() []string {
+	a.catalogMutex.RLock()
+	defer a.catalogMutex.RUnlock()
+
 	languages := make(map[string]bool)
 
 	for _, tmpl := range a.promptsTmpl {
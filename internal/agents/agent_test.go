@@ -0,0 +1,30 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileModes(t *testing.T) {
+	a := &Agent{outputDir: t.TempDir()}
+
+	for _, task := range []fileTask{
+		{Path: "model.sh", Content: "#!/bin/sh\necho hi\n"},
+		{Path: "bin/run", Content: "#!/bin/sh\n", Mode: 0755},
+	} {
+		if err := a.writeFile(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for p, want := range map[string]os.FileMode{"model.sh": 0644, "bin/run": 0755} {
+		info, err := os.Stat(filepath.Join(a.outputDir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s mode = %v, want %v", p, got, want)
+		}
+	}
+}
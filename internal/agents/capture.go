@@ -0,0 +1,548 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxCaptureFileSize is the largest file copied into a captured template.
+const maxCaptureFileSize = 1 << 20
+
+// CaptureOptions configures CaptureTemplate.
+type CaptureOptions struct {
+	Name        string
+	Description string
+	// Package is the module path or package name replaced with
+	// {{.Package}}; it is read from go.mod when empty.
+	Package string
+	// ProjectName is replaced with {{.ProjectName}} in paths, manifests and
+	// string literals; it is read from the project manifests or the
+	// directory name when empty.
+	ProjectName string
+}
+
+// CapturedFile is a file of a captured template, with its path and content
+// already escaped and templated.
+type CapturedFile struct {
+	Path    string
+	Content []byte
+	Mode    fs.FileMode
+}
+
+// CapturedTemplate is a template package built from an existing project.
+type CapturedTemplate struct {
+	Template ProjectTemplate
+	Files    []CapturedFile
+	// Notes describes what was detected and replaced, and what was skipped.
+	Notes []string
+}
+
+// CaptureTemplate walks the project in dir, honoring its .gitignore files,
+// and turns it into a template package: the module path and project name
+// are replaced with {{.Package}} and {{.ProjectName}}, the name only where
+// it cannot be an identifier of the code, detected values such
+// as the port become parameters, existing template delimiters are escaped
+// and a prompt describing the structure of the project is drafted.
+func CaptureTemplate(dir string, opts CaptureOptions) (*CapturedTemplate, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := os.DirFS(dir)
+	c := &capture{fsys: fsys, opts: opts}
+
+	if err := c.walk(); err != nil {
+		return nil, err
+	}
+
+	c.detect(filepath.Base(abs))
+
+	if c.opts.Name == "" {
+		c.opts.Name = c.projectName
+	}
+	if c.opts.Description == "" {
+		c.opts.Description = fmt.Sprintf("%s project captured from %s", languageTitle(c.language), filepath.Base(abs))
+	}
+
+	result := &CapturedTemplate{
+		Template: ProjectTemplate{
+			Name:        c.opts.Name,
+			Description: c.opts.Description,
+			Language:    c.language,
+			Parameters:  c.params,
+			Prompt:      c.draftPrompt(),
+		},
+		Notes: c.notes,
+	}
+
+	replacer := c.replacer()
+
+	for _, p := range c.files {
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := fs.Stat(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+
+		file := CapturedFile{
+			Path:    templatePath(c.templateSegments(p)),
+			Content: data,
+			Mode:    0644,
+		}
+
+		if info.Mode()&0111 != 0 {
+			file.Mode = 0755
+			result.Template.Executable = append(result.Template.Executable, file.Path)
+		}
+
+		if isText(data) {
+			file.Content = []byte(replacer(p, escapeTemplate(string(data))))
+		}
+
+		result.Files = append(result.Files, file)
+	}
+
+	return result, nil
+}
+
+// Write writes the captured template as a package in dir, which must not
+// exist or be empty.
+func (c *CapturedTemplate) Write(dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", dir)
+	}
+
+	manifest, err := yaml.Marshal(c.Template)
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "template.yaml"), manifest, 0644); err != nil {
+		return err
+	}
+
+	for _, file := range c.Files {
+		target := filepath.Join(dir, templateFilesDir, filepath.FromSlash(file.Path))
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(target, file.Content, file.Mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type capture struct {
+	fsys  fs.FS
+	opts  CaptureOptions
+	files []string
+	notes []string
+
+	language    string
+	projectName string
+	modulePath  string
+	params      []TemplateParameter
+	port        string
+	deps        []string
+}
+
+func (c *capture) note(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
+
+// walk lists the files of the project that are not ignored.
+func (c *capture) walk() error {
+	ignore := &gitignore{}
+
+	return fs.WalkDir(c.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == "." {
+			ignore.load(c.fsys, p)
+			return nil
+		}
+
+		if d.Name() == ".git" || ignore.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			ignore.load(c.fsys, p)
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			c.note("skipped %s: not a regular file", p)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxCaptureFileSize {
+			c.note("skipped %s: larger than %d bytes", p, maxCaptureFileSize)
+			return nil
+		}
+
+		c.files = append(c.files, p)
+
+		return nil
+	})
+}
+
+func (c *capture) has(p string) bool {
+	_, err := fs.Stat(c.fsys, p)
+	return err == nil
+}
+
+func (c *capture) read(p string) string {
+	data, _ := fs.ReadFile(c.fsys, p)
+	return string(data)
+}
+
+var (
+	goModuleRe    = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	goRequireRe   = regexp.MustCompile(`(?m)^\s*(?:require\s+)?([a-z0-9.\-]+\.[a-z]{2,}/\S+)\s+v\S+(?:\s+//\s*indirect)?\s*$`)
+	pyNameRe      = regexp.MustCompile(`(?m)^name\s*=\s*["']([^"']+)["']`)
+	exposeRe      = regexp.MustCompile(`(?m)^EXPOSE\s+(\d+)`)
+	envPortRe     = regexp.MustCompile(`(?m)^PORT\s*=\s*(\d+)`)
+	requirementRe = regexp.MustCompile(`(?m)^([A-Za-z0-9_.\-]+)`)
+)
+
+// detect works out the language, module path, project name, dependencies
+// and parameters of the project.
+func (c *capture) detect(dirName string) {
+	name := dirName
+
+	switch {
+	case c.has("go.mod"):
+		c.language = "go"
+		gomod := c.read("go.mod")
+		if m := goModuleRe.FindStringSubmatch(gomod); m != nil {
+			c.modulePath = m[1]
+			name = path.Base(m[1])
+		}
+		for _, m := range goRequireRe.FindAllStringSubmatch(gomod, -1) {
+			if !strings.Contains(m[0], "// indirect") {
+				c.deps = append(c.deps, m[1])
+			}
+		}
+	case c.has("package.json"):
+		c.language = "javascript"
+		var pkg struct {
+			Name         string            `json:"name"`
+			Dependencies map[string]string `json:"dependencies"`
+		}
+		if json.Unmarshal([]byte(c.read("package.json")), &pkg) == nil {
+			if pkg.Name != "" {
+				name = pkg.Name
+			}
+			c.deps = sortedKeys(pkg.Dependencies)
+		}
+	case c.has("pyproject.toml") || c.has("requirements.txt") || c.has("setup.py"):
+		c.language = "python"
+		if m := pyNameRe.FindStringSubmatch(c.read("pyproject.toml")); m != nil {
+			name = m[1]
+		}
+		for _, m := range requirementRe.FindAllStringSubmatch(c.read("requirements.txt"), -1) {
+			c.deps = append(c.deps, m[1])
+		}
+	case c.has("pom.xml") || c.has("build.gradle") || c.has("build.gradle.kts"):
+		c.language = "java"
+	default:
+		c.language = "default"
+	}
+
+	if c.opts.Package != "" {
+		c.modulePath = c.opts.Package
+	}
+	if c.modulePath != "" {
+		c.note("replaced module path %s with {{.Package}}", c.modulePath)
+	}
+
+	c.projectName = name
+	if c.opts.ProjectName != "" {
+		c.projectName = c.opts.ProjectName
+	}
+	if len(c.projectName) >= 3 {
+		c.note("replaced project name %s with {{.ProjectName}} in paths, manifests and string literals", c.projectName)
+	}
+
+	for _, p := range []string{"Dockerfile", ".env.example", ".env"} {
+		var m []string
+		if p == "Dockerfile" {
+			m = exposeRe.FindStringSubmatch(c.read(p))
+		} else {
+			m = envPortRe.FindStringSubmatch(c.read(p))
+		}
+		if m != nil {
+			c.port = m[1]
+			break
+		}
+	}
+
+	if c.port != "" {
+		port, _ := strconv.Atoi(c.port)
+		c.params = append(c.params, TemplateParameter{
+			Name:        "port",
+			Type:        ParamInt,
+			Default:     port,
+			Description: "Port the application listens on",
+		})
+		c.note("added parameter port (default %s)", c.port)
+	}
+}
+
+// captureManifests are the files where the project name is replaced
+// everywhere, not only in paths and string literals.
+var captureManifests = map[string]bool{
+	"go.mod":         true,
+	"package.json":   true,
+	"pyproject.toml": true,
+	"setup.py":       true,
+	"pom.xml":        true,
+}
+
+// stringLiteralRe matches single line string literals in the common
+// quoting styles, and raw strings.
+var stringLiteralRe = regexp.MustCompile(`"(?:[^"\\\n]|\\.)*"|'(?:[^'\\\n]|\\.)*'|` + "`[^`]*`")
+
+// replacer returns the function applied to the escaped content of the file
+// at p. Outside manifests the project name is only replaced as a path
+// segment, like cmd/app, or inside string literals: elsewhere it may be an
+// identifier, as in server := ...
+func (c *capture) replacer() func(p, content string) string {
+	var portRe *regexp.Regexp
+	if c.port != "" {
+		portRe = regexp.MustCompile(`(?m)^(EXPOSE\s+|PORT\s*=\s*)` + c.port + `\b`)
+	}
+
+	name := func(s string, i, end int) bool {
+		return !(i > 0 && isNameChar(s[i-1])) && !(end < len(s) && isWordChar(s[end]))
+	}
+	pathSegment := func(s string, i, end int) bool {
+		return name(s, i, end) && (i > 0 && s[i-1] == '/' || end < len(s) && s[end] == '/')
+	}
+	modulePath := func(s string, i, end int) bool {
+		return name(s, i, end) && !(i > 0 && s[i-1] == '/')
+	}
+	replaceName := func(s string) string {
+		return replaceWhere(s, c.projectName, "{{.ProjectName}}", name)
+	}
+
+	return func(p, content string) string {
+		if c.modulePath != "" {
+			content = replaceWhere(content, c.modulePath, "{{.Package}}", modulePath)
+		}
+
+		if len(c.projectName) >= 3 {
+			if captureManifests[path.Base(p)] {
+				content = replaceName(content)
+			} else {
+				content = replaceWhere(content, c.projectName, "{{.ProjectName}}", pathSegment)
+				content = stringLiteralRe.ReplaceAllStringFunc(content, replaceName)
+			}
+		}
+
+		if portRe != nil {
+			content = portRe.ReplaceAllString(content, "${1}{{.Params.port}}")
+		}
+
+		return content
+	}
+}
+
+// isWordChar tells the characters continuing a name or a module path, so
+// that app is not replaced in app-utils nor example.com/app in
+// example.com/app-utils. A slash or a dot after it, as in
+// example.com/app/internal or app.go, still ends it.
+func isWordChar(b byte) bool {
+	return b == '_' || b == '-' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func isNameChar(b byte) bool {
+	return b == '.' || isWordChar(b)
+}
+
+// replaceWhere replaces the occurrences of old in s at the positions where
+// ok, given s and the bounds of the occurrence, reports true.
+func replaceWhere(s, old, new string, ok func(s string, i, end int) bool) string {
+	var b strings.Builder
+	offset := 0
+
+	for {
+		i := strings.Index(s[offset:], old)
+		if i < 0 {
+			break
+		}
+		i += offset
+		end := i + len(old)
+
+		b.WriteString(s[offset:i])
+		if ok(s, i, end) {
+			b.WriteString(new)
+		} else {
+			b.WriteString(old)
+		}
+		offset = end
+	}
+
+	b.WriteString(s[offset:])
+
+	return b.String()
+}
+
+// templateSegments returns the path segments of p with the project name
+// templated and template delimiters escaped.
+func (c *capture) templateSegments(p string) []string {
+	segments := strings.Split(p, "/")
+
+	for i, segment := range segments {
+		if segment == c.projectName && len(c.projectName) >= 3 {
+			segments[i] = "{{.ProjectName}}"
+			continue
+		}
+		segments[i] = escapeTemplate(segment)
+	}
+
+	return segments
+}
+
+// templatePath joins the segments and appends the .tmpl suffix to files the
+// Go tooling would otherwise treat as part of the module holding the
+// template, and to files that already end in it.
+func templatePath(segments []string) string {
+	p := strings.Join(segments, "/")
+	base := path.Base(p)
+
+	switch {
+	case strings.HasSuffix(base, ".go"),
+		base == "go.mod", base == "go.sum", base == "go.work",
+		strings.HasSuffix(base, templateSuffix):
+		p += templateSuffix
+	}
+
+	return p
+}
+
+// escapeTemplate escapes the text/template delimiters already in s.
+func escapeTemplate(s string) string {
+	return strings.NewReplacer("{{", `{{"{{"}}`, "}}", `{{"}}"}}`).Replace(s)
+}
+
+// draftPrompt describes the structure and conventions of the project.
+func (c *capture) draftPrompt() string {
+	var b strings.Builder
+
+	dirs := make(map[string]int)
+	var tests []string
+	tooling := make(map[string]bool)
+
+	for _, p := range c.files {
+		if i := strings.Index(p, "/"); i > 0 {
+			dirs[p[:i]]++
+		}
+
+		base := path.Base(p)
+		switch {
+		case strings.HasSuffix(base, "_test.go"), strings.HasPrefix(base, "test_") && strings.HasSuffix(base, ".py"),
+			strings.Contains(base, ".test.") || strings.Contains(base, ".spec."), strings.HasSuffix(base, "Test.java"):
+			tests = append(tests, p)
+		case base == "Makefile":
+			tooling["Makefile"] = true
+		case base == "Dockerfile" || strings.HasPrefix(base, "docker-compose"):
+			tooling["Docker"] = true
+		case strings.HasPrefix(p, ".github/workflows/"):
+			tooling["GitHub Actions"] = true
+		case base == ".golangci.yml" || base == ".eslintrc.json" || base == ".eslintrc.js" || base == "ruff.toml":
+			tooling["linter configuration ("+base+")"] = true
+		}
+	}
+
+	fmt.Fprintf(&b, "- %s project following the structure of the files provided by the template", languageTitle(c.language))
+
+	if len(dirs) > 0 {
+		names := sortedKeys(dirs)
+		for i, d := range names {
+			if dirs[d] == 1 {
+				names[i] = d + "/ (1 file)"
+			} else {
+				names[i] = fmt.Sprintf("%s/ (%d files)", d, dirs[d])
+			}
+		}
+		fmt.Fprintf(&b, "\n- Top level layout: %s", strings.Join(names, ", "))
+	}
+
+	if len(c.deps) > 0 {
+		deps := c.deps
+		if len(deps) > 15 {
+			deps = deps[:15]
+		}
+		fmt.Fprintf(&b, "\n- Use the same libraries: %s", strings.Join(deps, ", "))
+	}
+
+	if len(tests) > 0 {
+		sort.Strings(tests)
+		fmt.Fprintf(&b, "\n- Write tests the way the existing ones are written, e.g. %s", tests[0])
+	}
+
+	if len(tooling) > 0 {
+		fmt.Fprintf(&b, "\n- Keep the build tooling working: %s", strings.Join(sortedKeys(tooling), ", "))
+	}
+
+	b.WriteString("\n- Extend the existing files rather than replacing them, and keep their naming, error handling and logging conventions")
+
+	return b.String()
+}
+
+func languageTitle(language string) string {
+	switch language {
+	case "go":
+		return "Go"
+	case "javascript":
+		return "JavaScript"
+	case "python":
+		return "Python"
+	case "java":
+		return "Java"
+	default:
+		return "Generic"
+	}
+}
@@ -0,0 +1,296 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+// maxChatHistory bounds the earlier messages sent with each chat request.
+const maxChatHistory = 20
+
+const chatSystemPrompt = `You are a coding assistant working with a developer on their project, one change request at a time. Each request comes with the current files of the project.
+Reply with a short explanation of what you change, then the complete new content of every file you create or modify, formatted like this for each file:
+
+---FILE_PATH: path/to/filename.ext
+[code content goes here]
+---END_FILE
+
+IMPORTANT:
+1. DO NOT include files that do not change.
+2. DO NOT include markdown code block markers in your code content.
+3. Always write whole files, never diffs or fragments.
+4. Keep the structure, style and conventions of the existing code.
+5. The developer reviews every file and may reject some of your changes; rejected changes are not in the project.`
+
+// ErrNothingToUndo is returned by Undo when no change is left to revert.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// FileChange is a file a chat turn proposes to create or modify.
+type FileChange struct {
+	Path string
+	// Old is the current content, empty when Created.
+	Old     string
+	New     string
+	Created bool
+
+	mode fs.FileMode
+}
+
+// ChatTurn is the reply to a chat request.
+type ChatTurn struct {
+	// Reply is the text of the reply around the file blocks.
+	Reply   string
+	Changes []FileChange
+	Usage   Usage
+	Cost    float64
+}
+
+// ChatSession is a conversation with the model about the project in the
+// output directory of an agent. Proposed changes are only written when
+// applied, and applied changes can be undone.
+type ChatSession struct {
+	agent   *Agent
+	ctx     context.Context
+	history []Message
+	applied [][]FileChange
+	// notes tell the model what happened to its last changes.
+	notes []string
+	cost  float64
+}
+
+// NewChatSession starts a conversation about the project in the output
+// directory.
+func (a *Agent) NewChatSession() *ChatSession {
+	return a.NewChatSessionContext(a.ctx)
+}
+
+// NewChatSessionContext is NewChatSession with a context carrying the span
+// the turns are traced under. Send cancels its model requests with ctx.
+func (a *Agent) NewChatSessionContext(ctx context.Context) *ChatSession {
+	return &ChatSession{agent: a, ctx: ctx}
+}
+
+// Send sends request with the current project files and returns the
+// changes proposed in the reply, without writing them.
+func (c *ChatSession) Send(request string) (*ChatTurn, error) {
+	return c.SendContext(c.ctx, request)
+}
+
+// SendContext is Send with a context for this turn only, e.g. one canceled
+// when the user interrupts it. It should derive from the session context.
+func (c *ChatSession) SendContext(ctx context.Context, request string) (turn *ChatTurn, err error) {
+	a := c.agent
+
+	ctx, span := tracing.Start(ctx, "chat.turn", logging.ModelKey, a.openAi.Model())
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	_, readSpan := tracing.Start(ctx, "project.read", "dir", a.outputDir)
+	files, listed, err := readProjectFiles(a.outputDir)
+	readSpan.SetAttributes("files", len(files), "listed", len(listed))
+	readSpan.RecordError(err)
+	readSpan.End()
+	if err != nil {
+		return nil, fmt.Errorf("reading project:%w", err)
+	}
+
+	var b strings.Builder
+	if len(files) == 0 && len(listed) == 0 {
+		b.WriteString("The project is empty.\n")
+	} else {
+		b.WriteString("Project files:\n\n")
+		b.WriteString(formatFileBlocks(files))
+	}
+
+	if len(listed) > 0 {
+		b.WriteString("\n\nOther files in the project, not shown:\n")
+		for _, p := range listed {
+			b.WriteString("- " + p + "\n")
+		}
+	}
+
+	request = strings.TrimSpace(strings.Join(append(c.notes, request), "\n\n"))
+
+	b.WriteString("\n\nChange request:\n")
+	b.WriteString(request)
+
+	if len(a.contextFiles) > 0 {
+		b.WriteString("\n\n" + formatContext(a.contextFiles))
+	}
+
+	messages := append([]Message{{Role: RoleSystem, Content: chatSystemPrompt}}, c.history...)
+	messages = append(messages, Message{Role: RoleUser, Content: b.String()})
+
+	res, err := a.openAi.QueryMessagesContext(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("%w:%w", ErrUpstream, err)
+	}
+
+	a.usageMutex.Lock()
+	a.usage.Add(res.Usage)
+	a.usageMutex.Unlock()
+
+	turn = &ChatTurn{
+		Usage: res.Usage,
+		Cost:  EstimateCost(a.openAi.Model(), res.Usage),
+	}
+	c.cost += turn.Cost
+
+	reply := res.Choices[0].Message.Content
+	turn.Reply = strings.TrimSpace(codeBlockRegex.ReplaceAllString(reply, ""))
+
+	// later requests carry fresh project files, so the history keeps the
+	// requests alone
+	c.notes = nil
+	c.history = append(c.history,
+		Message{Role: RoleUser, Content: request},
+		Message{Role: RoleAssistant, Content: reply},
+	)
+	if len(c.history) > maxChatHistory {
+		c.history = c.history[len(c.history)-maxChatHistory:]
+	}
+
+	for _, file := range ParseFiles(reply) {
+		if msg := checkFilePath(file.Path); msg != "" {
+			c.agent.logger.Warn("Skipping proposed file", logging.FileKey, file.Path, "reason", msg)
+			continue
+		}
+
+		change := FileChange{Path: filepath.ToSlash(filepath.Clean(file.Path)), New: file.Content + "\n"}
+
+		fullPath := filepath.Join(a.outputDir, change.Path)
+		if info, err := os.Stat(fullPath); err == nil {
+			data, err := os.ReadFile(fullPath)
+			if err != nil {
+				return nil, err
+			}
+			change.Old = string(data)
+			change.mode = info.Mode().Perm()
+		} else if errors.Is(err, os.ErrNotExist) {
+			change.Created = true
+		} else {
+			return nil, err
+		}
+
+		if !change.Created && strings.TrimSpace(change.Old) == strings.TrimSpace(change.New) {
+			continue
+		}
+
+		turn.Changes = append(turn.Changes, change)
+	}
+
+	return turn, nil
+}
+
+// Apply writes the accepted changes of a turn. They can be reverted
+// together with Undo.
+func (c *ChatSession) Apply(changes []FileChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var written []FileChange
+
+	for _, change := range changes {
+		if err := c.agent.writeFile(fileTask{Path: change.Path, Content: change.New, Mode: change.mode}); err != nil {
+			c.applied = append(c.applied, written)
+			return err
+		}
+		written = append(written, change)
+
+		c.agent.fileWriterMutex.Lock()
+		c.agent.filesWritten[change.Path] = true
+		c.agent.fileWriterMutex.Unlock()
+	}
+
+	c.applied = append(c.applied, written)
+
+	return nil
+}
+
+// Reject tells the model, with the next request, that changes were not
+// applied.
+func (c *ChatSession) Reject(changes []FileChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	c.notes = append(c.notes, "Note: I rejected your changes to "+changePaths(changes)+"; they are not in the project.")
+}
+
+// Undo reverts the last applied changes, removing the files they created,
+// and returns them.
+func (c *ChatSession) Undo() ([]FileChange, error) {
+	if len(c.applied) == 0 {
+		return nil, ErrNothingToUndo
+	}
+
+	changes := c.applied[len(c.applied)-1]
+	c.applied = c.applied[:len(c.applied)-1]
+
+	for _, change := range changes {
+		if change.Created {
+			if err := os.Remove(filepath.Join(c.agent.outputDir, change.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := c.agent.writeFile(fileTask{Path: change.Path, Content: change.Old, Mode: change.mode}); err != nil {
+			return nil, err
+		}
+	}
+
+	c.notes = append(c.notes, "Note: I undid your changes to "+changePaths(changes)+"; they are no longer in the project.")
+
+	return changes, nil
+}
+
+// Files returns the paths of the project files, sorted.
+func (c *ChatSession) Files() ([]string, error) {
+	files, listed, err := readProjectFiles(c.agent.outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := append(sortedKeys(files), listed...)
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// Model returns the model the session talks to.
+func (c *ChatSession) Model() string {
+	return c.agent.openAi.Model()
+}
+
+// SetModel switches the model for the next requests.
+func (c *ChatSession) SetModel(model string) {
+	c.agent.openAi.SetModel(model)
+}
+
+// Cost returns the tokens used so far and their estimated cost, each
+// request priced with the model it was sent to.
+func (c *ChatSession) Cost() (Usage, float64) {
+	return c.agent.Usage(), c.cost
+}
+
+func changePaths(changes []FileChange) string {
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,248 @@
+package agents
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+// loadAddons reads the add-on templates, embedded and from the addons/
+// directory of each template path. Add-ons use the ProjectTemplate format,
+// as JSON files or packages, and contribute a prompt fragment and files to
+// whatever template they are mixed into.
+func (a *Agent) loadAddons() {
+	a.addons = make(map[string]ProjectTemplate)
+
+	if entries, err := readTemplateDir(templatesFS, "templates/addons", "embedded/addons", false); err == nil {
+		a.addAddons(entries)
+	}
+
+	for _, dir := range a.templatePaths {
+		userAddonPath := filepath.Join(dir, "addons")
+		if entries, err := readTemplateDir(os.DirFS(userAddonPath), ".", userAddonPath, false); err == nil {
+			a.addAddons(entries)
+		}
+	}
+}
+
+func (a *Agent) addAddons(entries []templateEntry) {
+	for _, entry := range entries {
+		if entry.Err != nil {
+			a.logger.Warn("Invalid add-on format", logging.SourceKey, entry.Source, logging.ErrorKey, entry.Err)
+			continue
+		}
+
+		addon := entry.Template
+		if err := addon.checkFilePaths(); err != nil {
+			a.logger.Warn("Invalid add-on format", logging.SourceKey, entry.Source, logging.ErrorKey, err)
+			continue
+		}
+
+		if _, exists := a.addons[addon.Name]; exists {
+			a.logger.Info("User add-on overrides embedded add-on with same name", logging.TemplateKey, addon.Name)
+		}
+
+		a.addons[addon.Name] = addon
+	}
+}
+
+// UseAddons selects add-ons to mix into the template on top of the ones the
+// template declares itself.
+func (a *Agent) UseAddons(names ...string) error {
+	a.catalogMutex.RLock()
+	defer a.catalogMutex.RUnlock()
+
+	for _, name := range names {
+		if _, ok := a.addons[name]; !ok {
+			return fmt.Errorf("add-on %s not found", name)
+		}
+	}
+
+	a.selectedAddons = append(a.selectedAddons, names...)
+
+	return nil
+}
+
+func (a *Agent) ListAddons() []ProjectTemplate {
+	a.catalogMutex.RLock()
+	defer a.catalogMutex.RUnlock()
+
+	addons := make([]ProjectTemplate, 0, len(a.addons))
+
+	for _, addon := range a.addons {
+		addons = append(addons, addon)
+	}
+
+	return addons
+}
+
+// ResolveTemplate returns the named template with its parents merged in and
+// its mixins, plus extraAddons, applied. Each template goes on top of its
+// parent and its own mixins, so its files win over theirs; extraAddons go on
+// top of everything.
+func (a *Agent) ResolveTemplate(name string, extraAddons ...string) (ProjectTemplate, error) {
+	a.catalogMutex.RLock()
+	defer a.catalogMutex.RUnlock()
+
+	var applied []string
+
+	tmpl, err := a.resolveParents(name, map[string]bool{}, &applied)
+	if err != nil {
+		return ProjectTemplate{}, err
+	}
+
+	for _, addon := range extraAddons {
+		if tmpl, err = a.applyAddon(tmpl, tmpl.Name, tmpl.Language, addon, &applied); err != nil {
+			return ProjectTemplate{}, err
+		}
+	}
+
+	tmpl.Mixins = applied
+
+	return tmpl, nil
+}
+
+// resolveParents merges name with its parents and mixins, lowest priority
+// first: the parent, the mixins of name and name itself. applied collects
+// the add-ons merged so far, each one only once.
+func (a *Agent) resolveParents(name string, seen map[string]bool, applied *[]string) (ProjectTemplate, error) {
+	if seen[name] {
+		return ProjectTemplate{}, fmt.Errorf("template %s: inheritance cycle", name)
+	}
+	seen[name] = true
+
+	tmpl, ok := a.templates[name]
+	if !ok {
+		return ProjectTemplate{}, fmt.Errorf("template %s not found", name)
+	}
+
+	if tmpl.Extends == "" && len(tmpl.Mixins) == 0 {
+		return tmpl, nil
+	}
+
+	var base ProjectTemplate
+	if tmpl.Extends != "" {
+		parent, err := a.resolveParents(tmpl.Extends, seen, applied)
+		if err != nil {
+			return ProjectTemplate{}, err
+		}
+		base = parent
+	}
+
+	language := tmpl.Language
+	if language == "" {
+		language = base.Language
+	}
+
+	for _, addon := range tmpl.Mixins {
+		var err error
+		if base, err = a.applyAddon(base, tmpl.Name, language, addon, applied); err != nil {
+			return ProjectTemplate{}, err
+		}
+	}
+
+	merged := mergeTemplates(base, tmpl)
+	merged.Name = tmpl.Name
+	merged.Extends = tmpl.Extends
+	merged.Description = base.Description
+	merged.Language = language
+
+	if tmpl.Description != "" {
+		merged.Description = tmpl.Description
+	}
+
+	return merged, nil
+}
+
+// applyAddon merges the named add-on on top of tmpl, unless it was applied
+// already. templateName and language are those of the template the add-on
+// is mixed into.
+func (a *Agent) applyAddon(tmpl ProjectTemplate, templateName, language, name string, applied *[]string) (ProjectTemplate, error) {
+	if slices.Contains(*applied, name) {
+		return tmpl, nil
+	}
+
+	addon, ok := a.addons[name]
+	if !ok {
+		return ProjectTemplate{}, fmt.Errorf("template %s: add-on %s not found", templateName, name)
+	}
+
+	if addon.Language != "" && language != "" && !strings.EqualFold(addon.Language, language) {
+		return ProjectTemplate{}, fmt.Errorf("add-on %s is for %s projects, template %s is %s", addon.Name, addon.Language, templateName, language)
+	}
+
+	*applied = append(*applied, name)
+
+	return mergeTemplates(tmpl, addon), nil
+}
+
+// mergeTemplates layers the prompt and files of overlay on top of base.
+// Prompts are concatenated and files of overlay override those of base,
+// package trees included. A system prompt of overlay replaces the one of
+// base, examples and reference files add up. Sampling parameters of
+// overlay override those of base.
+func mergeTemplates(base, overlay ProjectTemplate) ProjectTemplate {
+	merged := base
+
+	merged.Prompt = joinPrompts(base.Prompt, overlay.Prompt)
+	merged.Parameters = mergeParameters(base.Parameters, overlay.Parameters)
+
+	if overlay.SystemPrompt != "" {
+		merged.SystemPrompt = overlay.SystemPrompt
+	}
+	merged.Examples = append(append([]TemplateExample{}, base.Examples...), overlay.Examples...)
+	merged.References = append(append([]string{}, base.References...), overlay.References...)
+	merged.Sampling = base.Sampling.Merge(overlay.Sampling)
+
+	overridden := make(map[string]bool, len(overlay.references))
+	for _, file := range overlay.references {
+		overridden[file.Path] = true
+	}
+	merged.references = nil
+	for _, file := range base.references {
+		if !overridden[file.Path] {
+			merged.references = append(merged.references, file)
+		}
+	}
+	merged.references = append(merged.references, overlay.references...)
+
+	merged.Files = make(map[string]string, len(base.Files)+len(overlay.Files))
+	merged.filesAfter = make(map[string]int, len(base.Files)+len(overlay.Files))
+	for p, content := range base.Files {
+		merged.Files[p] = content
+		merged.filesAfter[p] = base.fileLayer(p)
+	}
+	for p, content := range overlay.Files {
+		merged.Files[p] = content
+		merged.filesAfter[p] = len(base.trees) + overlay.fileLayer(p)
+	}
+
+	merged.trees = append(append([]templateTree{}, base.trees...), overlay.trees...)
+	merged.Executable = append(append([]string{}, base.Executable...), overlay.Executable...)
+
+	merged.Conditions = make(map[string]string, len(base.Conditions)+len(overlay.Conditions))
+	for p, condition := range base.Conditions {
+		merged.Conditions[p] = condition
+	}
+	for p, condition := range overlay.Conditions {
+		merged.Conditions[p] = condition
+	}
+
+	return merged
+}
+
+func joinPrompts(prompts ...string) string {
+	parts := make([]string, 0, len(prompts))
+
+	for _, p := range prompts {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,93 @@
+package agents
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+func TestResolveTemplateChildWinsOverMixins(t *testing.T) {
+	a := &Agent{
+		templates: map[string]ProjectTemplate{
+			"base": {Name: "base", Language: "go", Mixins: []string{"docker"}, Files: map[string]string{"Dockerfile": "base"}},
+			"app":  {Name: "app", Extends: "base", Mixins: []string{"ci"}, Files: map[string]string{"ci.yml": "app"}},
+		},
+		addons: map[string]ProjectTemplate{
+			"docker": {Name: "docker", Files: map[string]string{"Dockerfile": "docker", ".dockerignore": "docker"}},
+			"ci":     {Name: "ci", Files: map[string]string{"ci.yml": "ci", "Dockerfile": "ci"}},
+			"extra":  {Name: "extra", Files: map[string]string{"ci.yml": "extra"}},
+		},
+	}
+
+	tmpl, err := a.ResolveTemplate("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{
+		// the mixin of app goes over base, app over its mixin
+		"Dockerfile":    "ci",
+		"ci.yml":        "app",
+		".dockerignore": "docker",
+	} {
+		if got := tmpl.Files[p]; got != want {
+			t.Errorf("%s = %q, want %q", p, got, want)
+		}
+	}
+
+	// add-ons chosen for the generation go on top
+	tmpl, err = a.ResolveTemplate("app", "extra", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tmpl.Files["ci.yml"]; got != "extra" {
+		t.Errorf("ci.yml = %q, want extra", got)
+	}
+	if got := tmpl.Mixins; len(got) != 3 || got[0] != "docker" || got[1] != "ci" || got[2] != "extra" {
+		t.Errorf("mixins = %v", got)
+	}
+}
+
+func TestRenderTemplateLayersInlineAndPackageFiles(t *testing.T) {
+	childTree := fstest.MapFS{"main.go": {Data: []byte("package child\n")}}
+	parentTree := fstest.MapFS{"README.md": {Data: []byte("parent tree\n")}}
+
+	a := &Agent{
+		templates: map[string]ProjectTemplate{
+			"base": {
+				Name: "base", Language: "go",
+				Files: map[string]string{"main.go": "package base\n"},
+				trees: []templateTree{{fsys: parentTree, source: "base/files"}},
+			},
+			"app": {
+				Name: "app", Extends: "base",
+				Files: map[string]string{"README.md": "app inline\n"},
+				trees: []templateTree{{fsys: childTree, source: "app/files"}},
+			},
+		},
+		selectedTmpl: "app",
+		taskQueue:    make(chan fileTask, 10),
+		logger:       logging.Discard(),
+	}
+
+	if _, err := a.renderTemplate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(a.taskQueue)
+
+	got := make(map[string]string)
+	for task := range a.taskQueue {
+		got[task.Path] = task.Content
+	}
+
+	// the package file of app wins over the inline one of base, the inline
+	// file of app over the package file of base
+	if got["main.go"] != "package child\n" {
+		t.Errorf("main.go = %q", got["main.go"])
+	}
+	if got["README.md"] != "app inline\n" {
+		t.Errorf("README.md = %q", got["README.md"])
+	}
+}
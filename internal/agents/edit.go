@@ -0,0 +1,160 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+const (
+	// maxEditFileSize is the largest project file sent when editing.
+	maxEditFileSize = 256 << 10
+	// maxEditContextSize bounds the project content sent when editing;
+	// files past it are only listed by path.
+	maxEditContextSize = 1 << 20
+)
+
+const editSystemPrompt = `You are a code editing assistant. You are given the files of an existing project and a change request.
+Reply with the complete new content of every file you create or modify, formatted like this for each file:
+
+---FILE_PATH: path/to/filename.ext
+[code content goes here]
+---END_FILE
+
+IMPORTANT:
+1. DO NOT include files that do not change.
+2. DO NOT include markdown code block markers in your code content.
+3. Always write whole files, never diffs or fragments.
+4. Keep the structure, style and conventions of the existing code.`
+
+// EditCode applies instruction to the project in the output directory: the
+// project files, honoring its .gitignore files, are sent with the
+// instruction and the files in the reply are written back.
+func (a *Agent) EditCode(instruction string) error {
+	return a.EditCodeContext(a.ctx, instruction)
+}
+
+// EditCodeContext is EditCode with a context carrying the span the edit
+// steps are traced under. The model request is canceled with ctx.
+func (a *Agent) EditCodeContext(ctx context.Context, instruction string) error {
+	_, span := tracing.Start(ctx, "project.read", "dir", a.outputDir)
+	files, listed, err := readProjectFiles(a.outputDir)
+	span.SetAttributes("files", len(files), "listed", len(listed))
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("reading project:%w", err)
+	}
+
+	if len(files) == 0 && len(listed) == 0 {
+		return fmt.Errorf("no files found in %s", a.outputDir)
+	}
+
+	a.logger.Info("Editing project", "dir", a.outputDir, "files", len(files))
+
+	var b strings.Builder
+	b.WriteString("Project files:\n\n")
+	b.WriteString(formatFileBlocks(files))
+
+	if len(listed) > 0 {
+		b.WriteString("\n\nOther files in the project, not shown:\n")
+		for _, p := range listed {
+			b.WriteString("- " + p + "\n")
+		}
+	}
+
+	b.WriteString("\n\nChange request:\n")
+	b.WriteString(instruction)
+
+	if len(a.contextFiles) > 0 {
+		b.WriteString("\n\n" + formatContext(a.contextFiles))
+	}
+
+	res, err := a.openAi.QueryMessagesContext(ctx, []Message{
+		{Role: RoleSystem, Content: editSystemPrompt},
+		{Role: RoleUser, Content: b.String()},
+	})
+
+	if err != nil {
+		return fmt.Errorf("%w:%w", ErrUpstream, err)
+	}
+
+	a.reportCached(res)
+
+	a.usageMutex.Lock()
+	a.usage.Add(res.Usage)
+	a.usageMutex.Unlock()
+
+	if err = a.parseCode(ctx, res.Choices[0].Message.Content); err != nil {
+		return fmt.Errorf("error parsing code:%w", err)
+	}
+
+	return nil
+}
+
+// readProjectFiles reads the text files of the project in dir, skipping
+// .git and what its .gitignore files exclude. Files that do not fit in
+// maxEditContextSize are returned in listed.
+func readProjectFiles(dir string) (files map[string]string, listed []string, err error) {
+	fsys := os.DirFS(dir)
+	files = make(map[string]string)
+	size := 0
+
+	var ignore gitignore
+
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == "." {
+			ignore.load(fsys, p)
+			return nil
+		}
+
+		if d.Name() == ".git" || ignore.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			ignore.load(fsys, p)
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxEditFileSize || size+int(info.Size()) > maxEditContextSize {
+			listed = append(listed, p)
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if !isText(data) {
+			listed = append(listed, p)
+			return nil
+		}
+
+		files[p] = string(data)
+		size += len(data)
+
+		return nil
+	})
+
+	return files, listed, err
+}
@@ -0,0 +1,541 @@
+package agents
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sourceFile records where a fetched template source came from. Dot files
+// are not read as templates.
+const sourceFile = ".source.json"
+
+// Limits of template bundles. maxSourceSize caps the download,
+// maxExtractedSize and maxArchiveEntries what an archive unpacks to, so
+// that a small compressed bundle cannot fill the disk.
+const (
+	maxSourceSize     = 100 << 20
+	maxExtractedSize  = 500 << 20
+	maxArchiveEntries = 10000
+)
+
+// TemplateSource is a template bundle fetched into the cache.
+type TemplateSource struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Ref       string    `json:"ref,omitempty"`
+	Dir       string    `json:"-"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// Fetcher downloads template bundles from git repositories, tarballs and zip
+// files, over http(s) or from file:// URLs, into the template cache, where
+// DefaultTemplatePaths picks them up.
+type Fetcher struct {
+	CacheDir string
+	Client   *http.Client
+}
+
+// NewFetcher returns a fetcher using TemplateCacheDir.
+func NewFetcher() (*Fetcher, error) {
+	dir, err := TemplateCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fetcher{
+		CacheDir: dir,
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+var (
+	sourceNameRe    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	sourceInvalidRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+func validSourceName(name string) bool {
+	return sourceNameRe.MatchString(name)
+}
+
+// SourceName derives the cache directory name of a source from its URL.
+func SourceName(rawURL string) string {
+	rawURL, _, _ = strings.Cut(rawURL, "#")
+	rawURL = strings.TrimSuffix(rawURL, "/")
+
+	name := path.Base(strings.ReplaceAll(rawURL, ":", "/"))
+	for _, suffix := range []string{".git", ".tar.gz", ".tgz", ".tar", ".zip"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+
+	name = sourceInvalidRe.ReplaceAllString(name, "-")
+	name = strings.TrimLeft(name, ".-_")
+	if name == "" {
+		name = "templates"
+	}
+
+	return name
+}
+
+// Fetch downloads the source at rawURL into the cache directory name,
+// replacing what was there. A ref, or a "#ref" suffix in the URL, selects
+// the branch or tag of git sources.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL, name, ref string) (TemplateSource, error) {
+	if base, fragment, ok := strings.Cut(rawURL, "#"); ok {
+		rawURL = base
+		if ref == "" {
+			ref = fragment
+		}
+	}
+
+	if name == "" {
+		name = SourceName(rawURL)
+	}
+	if !validSourceName(name) {
+		return TemplateSource{}, fmt.Errorf("invalid source name %q", name)
+	}
+
+	if err := os.MkdirAll(f.CacheDir, 0755); err != nil {
+		return TemplateSource{}, err
+	}
+
+	tmp, err := os.MkdirTemp(f.CacheDir, ".fetch-")
+	if err != nil {
+		return TemplateSource{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	content := filepath.Join(tmp, "content")
+
+	if err := f.download(ctx, rawURL, ref, content); err != nil {
+		return TemplateSource{}, fmt.Errorf("fetching %s: %w", rawURL, err)
+	}
+
+	root, err := bundleRoot(content)
+	if err != nil {
+		return TemplateSource{}, err
+	}
+
+	source := TemplateSource{
+		Name:      name,
+		URL:       rawURL,
+		Ref:       ref,
+		Dir:       filepath.Join(f.CacheDir, name),
+		FetchedAt: time.Now().UTC(),
+	}
+
+	data, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return source, err
+	}
+	if err := os.WriteFile(filepath.Join(root, sourceFile), data, 0644); err != nil {
+		return source, err
+	}
+
+	// swap the new content in, keeping the old one until it is in place
+	old := filepath.Join(tmp, "old")
+	if err := os.Rename(source.Dir, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return source, err
+	}
+	if err := os.Rename(root, source.Dir); err != nil {
+		os.Rename(old, source.Dir)
+		return source, err
+	}
+
+	return source, nil
+}
+
+// Sources lists the fetched sources.
+func (f *Fetcher) Sources() ([]TemplateSource, error) {
+	entries, err := os.ReadDir(f.CacheDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []TemplateSource
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !validSourceName(entry.Name()) {
+			continue
+		}
+
+		dir := filepath.Join(f.CacheDir, entry.Name())
+		source := TemplateSource{Name: entry.Name(), Dir: dir}
+
+		if data, err := os.ReadFile(filepath.Join(dir, sourceFile)); err == nil {
+			json.Unmarshal(data, &source)
+			source.Name = entry.Name()
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// Remove deletes a fetched source.
+func (f *Fetcher) Remove(name string) error {
+	if !validSourceName(name) {
+		return fmt.Errorf("invalid source name %q", name)
+	}
+
+	dir := filepath.Join(f.CacheDir, name)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("source %s not found", name)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// download puts the content of the source in dir.
+func (f *Fetcher) download(ctx context.Context, rawURL, ref, dir string) error {
+	if isGitURL(rawURL) {
+		return gitClone(ctx, strings.TrimPrefix(rawURL, "git+"), ref, dir)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "file":
+		local := filepath.FromSlash(u.Path)
+		if u.Host != "" && u.Host != "localhost" {
+			// file://relative/path
+			local = filepath.Join(u.Host, local)
+		}
+
+		info, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if _, err := os.Stat(filepath.Join(local, ".git")); err == nil && ref != "" {
+				return gitClone(ctx, local, ref, dir)
+			}
+			return copyDir(local, dir)
+		}
+
+		data, err := os.ReadFile(local)
+		if err != nil {
+			return err
+		}
+
+		return extractArchive(data, local, dir)
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return err
+		}
+
+		client := f.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxSourceSize {
+			return fmt.Errorf("bundle larger than %d bytes", maxSourceSize)
+		}
+
+		return extractArchive(data, u.Path, dir)
+	default:
+		return fmt.Errorf("unsupported source %q, expected a git repository, a tarball or zip URL, or a file:// path", rawURL)
+	}
+}
+
+func isGitURL(rawURL string) bool {
+	switch {
+	case strings.HasPrefix(rawURL, "git+"), strings.HasPrefix(rawURL, "git://"),
+		strings.HasPrefix(rawURL, "ssh://"), strings.HasPrefix(rawURL, "git@"):
+		return true
+	case strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://"):
+		return strings.HasSuffix(strings.TrimSuffix(rawURL, "/"), ".git")
+	}
+
+	return false
+}
+
+func gitClone(ctx context.Context, repo, ref, dir string) error {
+	args := []string{"clone", "--depth", "1", "--quiet"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", repo, dir)
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git clone: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.RemoveAll(filepath.Join(dir, ".git"))
+}
+
+// extractLimits is what is left of the size and entry limits while a
+// bundle is unpacked or copied.
+type extractLimits struct {
+	bytes   int64
+	entries int
+}
+
+func defaultExtractLimits() *extractLimits {
+	return &extractLimits{bytes: maxExtractedSize, entries: maxArchiveEntries}
+}
+
+// entry counts an archive entry, failing past the limit.
+func (l *extractLimits) entry() error {
+	if l.entries--; l.entries < 0 {
+		return fmt.Errorf("bundle has more than %d files", maxArchiveEntries)
+	}
+
+	return nil
+}
+
+// extractArchive unpacks a zip file or a tarball, gzipped or not, into dir.
+func extractArchive(data []byte, name, dir string) error {
+	limits := defaultExtractLimits()
+
+	if strings.HasSuffix(name, ".zip") || bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return extractZip(data, dir, limits)
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return extractTar(r, dir, limits)
+}
+
+func extractTar(r io.Reader, dir string, limits *extractLimits) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tarball: %w", err)
+		}
+
+		if err := limits.entry(); err != nil {
+			return err
+		}
+
+		target := archiveTarget(dir, hdr.Name)
+		if target == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(target, tr, fs.FileMode(hdr.Mode), limits); err != nil {
+				return err
+			}
+		default:
+			// links and devices are not part of templates
+		}
+	}
+}
+
+func extractZip(data []byte, dir string, limits *extractLimits) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("reading zip: %w", err)
+	}
+
+	for _, file := range zr.File {
+		if err := limits.entry(); err != nil {
+			return err
+		}
+
+		target := archiveTarget(dir, file.Name)
+		if target == "" {
+			continue
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(target, rc, file.Mode(), limits)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveTarget returns where an archive entry is extracted. Names are
+// cleaned as absolute paths so entries cannot end up outside dir.
+func archiveTarget(dir, name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// writeArchiveFile writes an archive entry, failing once the archive
+// unpacks to more than the size limit.
+func writeArchiveFile(target string, r io.Reader, mode fs.FileMode, limits *extractLimits) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	perm := fs.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(file, io.LimitReader(r, limits.bytes+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if limits.bytes -= n; limits.bytes < 0 {
+		return fmt.Errorf("bundle unpacks to more than %d bytes", maxExtractedSize)
+	}
+
+	return nil
+}
+
+func copyDir(src, dst string) error {
+	limits := defaultExtractLimits()
+
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if err := limits.entry(); err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return writeArchiveFile(target, file, info.Mode(), limits)
+	})
+}
+
+// bundleRoot returns the directory holding the templates of a bundle: dir,
+// or its only subdirectory when the archive wraps everything in one, as
+// GitHub tarballs do.
+func bundleRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 1 && entries[0].IsDir() && entries[0].Name() != "addons" && entries[0].Name() != "prompts" {
+		sub := filepath.Join(dir, entries[0].Name())
+		for _, manifest := range manifestNames {
+			if _, err := os.Stat(filepath.Join(sub, manifest)); err == nil {
+				// a single template package, not a wrapper
+				return dir, nil
+			}
+		}
+		return sub, nil
+	}
+
+	return dir, nil
+}
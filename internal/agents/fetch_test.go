@@ -0,0 +1,291 @@
+package agents
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+type archiveEntry struct {
+	name string
+	body string
+}
+
+func tarball(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func zipFile(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// listFiles returns the files under dir, relative to it.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestArchiveTarget(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+
+	for name, want := range map[string]string{
+		"go-cli/template.yaml":  "go-cli/template.yaml",
+		"../../etc/passwd":      "etc/passwd",
+		"/etc/passwd":           "etc/passwd",
+		"a/../../b":             "b",
+		"..\\..\\windows\\evil": "windows/evil",
+		"./prompts/../x.md":     "x.md",
+		"":                      "",
+		"..":                    "",
+		"/":                     "",
+	} {
+		got := archiveTarget(dir, name)
+		if want == "" {
+			if got != "" {
+				t.Errorf("archiveTarget(%q) = %q, want it skipped", name, got)
+			}
+			continue
+		}
+
+		if got != filepath.Join(dir, filepath.FromSlash(want)) {
+			t.Errorf("archiveTarget(%q) = %q, want %s under the dir", name, got, want)
+		}
+	}
+}
+
+func TestExtractTarKeepsEntriesInDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "out")
+
+	data := tarball(t,
+		archiveEntry{"go-cli/template.yaml", "name: go-cli\n"},
+		archiveEntry{"../escaped.txt", "x"},
+		archiveEntry{"/abs.txt", "x"},
+	)
+
+	if err := extractArchive(data, "bundle.tar.gz", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err == nil {
+		t.Error("entry written outside the dir")
+	}
+
+	got := strings.Join(listFiles(t, dir), ",")
+	if got != "abs.txt,escaped.txt,go-cli/template.yaml" {
+		t.Errorf("files = %s", got)
+	}
+}
+
+func TestExtractTarSizeLimit(t *testing.T) {
+	// compresses to a few hundred bytes
+	data := tarball(t, archiveEntry{"big.txt", strings.Repeat("0", 1<<20)})
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := &extractLimits{bytes: 64 << 10, entries: maxArchiveEntries}
+	err = extractTar(gz, t.TempDir(), limits)
+	if err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Fatalf("err = %v, want the size limit", err)
+	}
+}
+
+func TestExtractTarEntryLimit(t *testing.T) {
+	var entries []archiveEntry
+	for _, name := range []string{"a", "b", "c", "d"} {
+		entries = append(entries, archiveEntry{name, name})
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(tarball(t, entries...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := &extractLimits{bytes: maxExtractedSize, entries: 3}
+	err = extractTar(gz, t.TempDir(), limits)
+	if err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Fatalf("err = %v, want the entry limit", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "out")
+
+	data := zipFile(t,
+		archiveEntry{"go-cli/template.yaml", "name: go-cli\n"},
+		archiveEntry{"../../escaped.txt", "x"},
+	)
+
+	if err := extractArchive(data, "bundle.zip", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err == nil {
+		t.Error("entry written outside the dir")
+	}
+
+	got := strings.Join(listFiles(t, dir), ",")
+	if got != "escaped.txt,go-cli/template.yaml" {
+		t.Errorf("files = %s", got)
+	}
+
+	limits := &extractLimits{bytes: 4, entries: maxArchiveEntries}
+	if err := extractZip(data, t.TempDir(), limits); err == nil {
+		t.Error("expected the size limit")
+	}
+}
+
+func TestFetchFileTarball(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	data := tarball(t,
+		archiveEntry{"bundle-main/go-cli/template.yaml", "name: go-cli\n"},
+		archiveEntry{"../bundle-main/outside.txt", "x"},
+	)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Fetcher{CacheDir: t.TempDir()}
+
+	source, err := f.Fetch(context.Background(), "file://"+filepath.ToSlash(src), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if source.Name != "bundle" {
+		t.Errorf("name = %s, want bundle", source.Name)
+	}
+
+	got := strings.Join(listFiles(t, f.CacheDir), ",")
+	if got != "bundle/.source.json,bundle/go-cli/template.yaml,bundle/outside.txt" {
+		t.Errorf("files = %s", got)
+	}
+}
+
+func TestFetchHTTPTarball(t *testing.T) {
+	data := tarball(t, archiveEntry{"go-cli/template.yaml", "name: go-cli\n"})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/templates.tgz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{CacheDir: t.TempDir(), Client: srv.Client()}
+
+	source, err := f.Fetch(context.Background(), srv.URL+"/templates.tgz", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(source.Dir, "go-cli", "template.yaml")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := f.Fetch(context.Background(), srv.URL+"/missing.tgz", "", ""); err == nil {
+		t.Error("expected an error for a missing bundle")
+	}
+}
+
+func TestFetchedTemplateCannotWriteOutside(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "evil.tar.gz")
+	manifest := "name: evil\ndescription: d\nlanguage: go\nfiles:\n  ../../.bashrc: pwned\n"
+	if err := os.WriteFile(src, tarball(t, archiveEntry{"evil/template.yaml", manifest}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Fetcher{CacheDir: t.TempDir()}
+	source, err := f.Fetch(context.Background(), "file://"+filepath.ToSlash(src), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Agent{templates: make(map[string]ProjectTemplate), logger: logging.Discard()}
+	if loaded := a.loadTemplatesFrom(os.DirFS(source.Dir), ".", source.Dir); loaded != 0 {
+		t.Errorf("loaded %d templates, want the evil one skipped", loaded)
+	}
+
+	root := t.TempDir()
+	a.outputDir = filepath.Join(root, "out")
+	for _, p := range []string{"../.bashrc", "/etc/x", "a/../../b"} {
+		if err := a.writeFile(fileTask{Path: p, Content: "x"}); err == nil {
+			t.Errorf("%s written", p)
+		}
+	}
+	if err := a.writeFile(fileTask{Path: "cmd/app/main.go", Content: "package main\n"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,149 @@
+package agents
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file, relative to the directory
+// holding it.
+type ignoreRule struct {
+	base     string
+	pattern  *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// gitignore matches paths against the .gitignore files of a tree, with the
+// usual rules: later patterns win, "!" negates, a trailing "/" only matches
+// directories, a pattern containing "/" is relative to its .gitignore and
+// "**" spans directories.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+// load adds the rules of the .gitignore in dir, if there is one.
+func (g *gitignore) load(fsys fs.FS, dir string) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.pattern = re
+
+		g.rules = append(g.rules, rule)
+	}
+}
+
+// ignored reports whether p, a slash separated path relative to the root of
+// the tree, is ignored.
+func (g *gitignore) ignored(p string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel := p
+		if rule.base != "." {
+			if !strings.HasPrefix(p, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(p, rule.base+"/")
+		}
+
+		subject := rel
+		if !rule.anchored {
+			subject = path.Base(rel)
+		}
+
+		if rule.pattern.MatchString(subject) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// globToRegexp translates a gitignore glob to a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
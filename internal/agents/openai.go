@@ -7,23 +7,64 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lFer17/codebase-maker/internal/cache"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
 )
 
 const (
-	OpenApiEndpoint = "https://api.openai.com/v1/chat/completions"
+	// DefaultBaseURL is the OpenAI API. Other OpenAI compatible APIs are
+	// used by setting OpenAIConfig.BaseURL.
+	DefaultBaseURL  = "https://api.openai.com/v1"
+	OpenApiEndpoint = DefaultBaseURL + "/chat/completions"
 )
 
+type ModelInfo struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// Models lists the OpenAI models offered by the CLI and the web UI.
+var Models = []ModelInfo{
+	{ID: "gpt-4o-mini", Description: "GPT-4o Mini, fast and cheap"},
+	{ID: "gpt-4o", Description: "GPT-4o"},
+	{ID: "gpt-4.1-mini", Description: "GPT-4.1 Mini"},
+	{ID: "gpt-4.1", Description: "GPT-4.1"},
+	{ID: "o3-mini", Description: "o3-mini reasoning model"},
+	{ID: "o4-mini", Description: "o4-mini reasoning model"},
+}
+
 type OpenAPIResponse struct {
 	Choices []struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage Usage `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error,omitempty"`
+	// Cached is set when the response comes from the response cache, in
+	// which case Usage is zero.
+	Cached bool `json:"-"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
 }
 
 type OpenAPI struct {
@@ -31,17 +72,63 @@ type OpenAPI struct {
 	ctx        context.Context
 	apikey     string
 	model      string
+	endpoint   string
+	retries    int
+	logger     *slog.Logger
+	cache      *cache.Cache
+	sampling   SamplingParams
+}
+
+type OpenAIConfig struct {
+	APIKey string
+	Model  string
+	// BaseURL defaults to DefaultBaseURL.
+	BaseURL string
+	// Retries is how many times a request is sent again after a network
+	// error, a rate limit or a server error.
+	Retries    int
+	HTTPClient *http.Client
+	// Logger defaults to slog.Default(). Prompts are logged at debug level
+	// under logging.PromptKey, so handlers can redact them.
+	Logger *slog.Logger
+	// Cache, if set, answers requests identical to earlier ones: same
+	// endpoint, model, messages and parameters.
+	Cache *cache.Cache
+	// Sampling are the parameters of every request, on top of those of the
+	// template.
+	Sampling SamplingParams
 }
 
 func NewOpenAI(ctx context.Context, apiKey string, model string, httpClient *http.Client) *OpenAPI {
+	return NewOpenAIWithConfig(ctx, OpenAIConfig{
+		APIKey:     apiKey,
+		Model:      model,
+		HTTPClient: httpClient,
+	})
+}
+
+func NewOpenAIWithConfig(ctx context.Context, cfg OpenAIConfig) *OpenAPI {
 	o := &OpenAPI{
 		ctx:        ctx,
-		apikey:     apiKey,
-		model:      model,
-		httpClient: httpClient,
+		apikey:     cfg.APIKey,
+		model:      cfg.Model,
+		httpClient: cfg.HTTPClient,
+		endpoint:   OpenApiEndpoint,
+		retries:    cfg.Retries,
+		logger:     cfg.Logger,
+		cache:      cfg.Cache,
+		sampling:   cfg.Sampling,
+	}
+
+	if o.logger == nil {
+		o.logger = slog.Default()
 	}
 
-	if httpClient == nil {
+	if cfg.BaseURL != "" {
+		o.endpoint = strings.TrimSuffix(cfg.BaseURL, "/") + "/chat/completions"
+	}
+
+	if o.httpClient == nil {
 		o.httpClient = &http.Client{
 			Timeout: time.Second * 120,
 		}
@@ -50,35 +137,191 @@ func NewOpenAI(ctx context.Context, apiKey string, model string, httpClient *htt
 	return o
 }
 
+func (o *OpenAPI) Model() string {
+	return o.model
+}
+
+// SetModel changes the model used by the next requests.
+func (o *OpenAPI) SetModel(model string) {
+	o.model = model
+}
+
+// Sampling returns the sampling parameters of the client.
+func (o *OpenAPI) Sampling() SamplingParams {
+	return o.sampling
+}
+
+// Message is a chat message sent to the model.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
 func (o *OpenAPI) Query(systemPrompt, prompt string) (OpenAPIResponse, error) {
-	var response OpenAPIResponse
+	return o.QueryContext(o.ctx, systemPrompt, prompt)
+}
 
+// QueryContext is Query with a context, which cancels the request and
+// carries the span the request is traced under.
+func (o *OpenAPI) QueryContext(ctx context.Context, systemPrompt, prompt string) (OpenAPIResponse, error) {
 	if systemPrompt == "" {
 		systemPrompt = "You are a helpful assistant."
 	}
 
-	bs, err := json.Marshal(map[string]interface{}{
-		"model": o.model,
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": systemPrompt,
-			},
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
+	return o.QueryMessagesContext(ctx, []Message{
+		{Role: RoleSystem, Content: systemPrompt},
+		{Role: RoleUser, Content: prompt},
 	})
+}
+
+// QueryMessages sends a whole conversation, e.g. a system prompt followed
+// by few-shot example exchanges and the user prompt.
+func (o *OpenAPI) QueryMessages(messages []Message) (OpenAPIResponse, error) {
+	return o.QueryMessagesContext(o.ctx, messages)
+}
+
+// QueryMessagesContext is QueryMessages with a context, which cancels the
+// request and carries the span the request is traced under.
+func (o *OpenAPI) QueryMessagesContext(ctx context.Context, messages []Message) (OpenAPIResponse, error) {
+	return o.QueryMessagesWithSampling(ctx, messages, o.sampling)
+}
+
+// QueryMessagesWithSampling is QueryMessagesContext with other sampling
+// parameters than those of the client. Parameters the model does not
+// support are left out of the request.
+func (o *OpenAPI) QueryMessagesWithSampling(ctx context.Context, messages []Message, sampling SamplingParams) (response OpenAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "llm.query", logging.ModelKey, o.model, "messages", len(messages))
+	defer func() {
+		span.SetAttributes(
+			"prompt_tokens", response.Usage.PromptTokens,
+			"completion_tokens", response.Usage.CompletionTokens,
+			"total_tokens", response.Usage.TotalTokens)
+		span.RecordError(err)
+		span.End()
+	}()
+
+	logger := o.logger.With(logging.ModelKey, o.model)
+
+	body := map[string]interface{}{
+		"model":    o.model,
+		"messages": messages,
+	}
+
+	if ignored := sampling.requestFields(o.model, body); len(ignored) > 0 {
+		logger.Warn("Model does not support some sampling parameters, leaving them out", "params", strings.Join(ignored, ", "))
+	}
+	if sampling.Seed != nil {
+		span.SetAttributes("seed", *sampling.Seed)
+	}
+
+	bs, err := json.Marshal(body)
 
 	if err != nil {
 		return response, err
 	}
 
-	req, err := http.NewRequestWithContext(o.ctx, "POST", OpenApiEndpoint, bytes.NewBuffer(bs))
+	var cacheKey string
+	if o.cache != nil {
+		cacheKey = cache.Key([]byte(o.endpoint), bs)
+		if cached, ok := o.cachedResponse(cacheKey); ok {
+			logger.Info("Using cached OpenAI response", "cache_key", cacheKey[:12])
+			span.SetAttributes("cached", true)
+			return cached, nil
+		}
+	}
+
+	if len(messages) > 0 {
+		logger.Debug("Sending OpenAI request", "messages", len(messages), logging.PromptKey, messages[len(messages)-1].Content)
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+
+		attemptCtx, attemptSpan := tracing.Start(ctx, "llm.request", "attempt", attempt+1)
+		start := time.Now()
+		response, retryAfter, err = o.send(attemptCtx, bs)
+		duration := time.Since(start)
+		attemptSpan.RecordError(err)
+		attemptSpan.End()
+
+		span.SetAttributes("attempts", attempt+1)
+
+		if err == nil {
+			logger.Info("OpenAI request completed",
+				"attempt", attempt+1,
+				"duration", duration,
+				"prompt_tokens", response.Usage.PromptTokens,
+				"completion_tokens", response.Usage.CompletionTokens)
+			if cacheKey != "" {
+				o.storeResponse(logger, cacheKey, response)
+			}
+			return response, nil
+		}
+		if retryAfter < 0 || attempt >= o.retries {
+			logger.Error("OpenAI request failed", "attempt", attempt+1, "duration", duration, logging.ErrorKey, err)
+			return response, err
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = time.Second << attempt
+		}
+
+		logger.Warn("OpenAI request failed, retrying", "attempt", attempt+1, "retry_in", delay, logging.ErrorKey, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return response, err
+		}
+	}
+}
+
+// cachedResponse returns the cached response of key. Cached responses cost
+// no tokens, so their usage is zero.
+func (o *OpenAPI) cachedResponse(key string) (OpenAPIResponse, bool) {
+	var response OpenAPIResponse
+
+	data, ok := o.cache.Get(key)
+	if !ok {
+		return response, false
+	}
+
+	if err := json.Unmarshal(data, &response); err != nil || len(response.Choices) == 0 {
+		return response, false
+	}
+
+	response.Usage = Usage{}
+	response.Cached = true
+
+	return response, true
+}
+
+func (o *OpenAPI) storeResponse(logger *slog.Logger, key string, response OpenAPIResponse) {
+	data, err := json.Marshal(response)
+	if err == nil {
+		err = o.cache.Put(key, data)
+	}
+
+	if err != nil {
+		logger.Warn("Could not cache OpenAI response", logging.ErrorKey, err)
+	}
+}
+
+// send posts the request body once. retryAfter is negative when the error
+// is not worth retrying, otherwise it is the delay the API asked for, or
+// zero.
+func (o *OpenAPI) send(ctx context.Context, bs []byte) (response OpenAPIResponse, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", o.endpoint, bytes.NewBuffer(bs))
 
 	if err != nil {
-		return response, fmt.Errorf("error creating request:%w", err)
+		return response, -1, fmt.Errorf("error creating request:%w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -87,25 +330,36 @@ func (o *OpenAPI) Query(systemPrompt, prompt string) (OpenAPIResponse, error) {
 	resp, err := o.httpClient.Do(req)
 
 	if err != nil {
-		return response, fmt.Errorf("error making request:%w", err)
+		if ctx.Err() != nil {
+			return response, -1, fmt.Errorf("error making request:%w", err)
+		}
+		return response, 0, fmt.Errorf("error making request:%w", err)
 	}
 	defer resp.Body.Close()
 
+	retryAfter = -1
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		retryAfter = 0
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return response, fmt.Errorf("error reading response")
+		return response, retryAfter, fmt.Errorf("error reading response")
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
-		return response, fmt.Errorf("error unmarshalling response:%w", err)
+		return response, retryAfter, fmt.Errorf("error unmarshalling response:%w", err)
 	}
 
 	if response.Error != nil {
-		return response, fmt.Errorf("API error:%s", response.Error.Message)
+		return response, retryAfter, fmt.Errorf("API error:%s", response.Error.Message)
 	}
 
 	if len(response.Choices) == 0 {
-		return response, errors.New("no choices returned from API")
+		return response, -1, errors.New("no choices returned from API")
 	}
-	return response, nil
+	return response, -1, nil
 }
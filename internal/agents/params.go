@@ -0,0 +1,170 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+	ParamEnum   = "enum"
+)
+
+// TemplateParameter describes a value the user can set when generating from
+// a template. Values are available to file templates and prompt templates
+// as {{.Params.name}}.
+type TemplateParameter struct {
+	Name        string      `json:"name" yaml:"name"`
+	Type        string      `json:"type,omitempty" yaml:"type,omitempty"`
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Enum        []string    `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Required    bool        `json:"required,omitempty" yaml:"required,omitempty"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+func (p TemplateParameter) kind() string {
+	if p.Type == "" {
+		if len(p.Enum) > 0 {
+			return ParamEnum
+		}
+		return ParamString
+	}
+
+	return p.Type
+}
+
+// convert parses raw into the type of the parameter.
+func (p TemplateParameter) convert(raw string) (interface{}, error) {
+	switch p.kind() {
+	case ParamString:
+		if len(p.Enum) > 0 && !slices.Contains(p.Enum, raw) {
+			return nil, fmt.Errorf("parameter %s: %q is not one of %s", p.Name, raw, strings.Join(p.Enum, ", "))
+		}
+		return raw, nil
+	case ParamInt:
+		v, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %q is not an integer", p.Name, raw)
+		}
+		return v, nil
+	case ParamBool:
+		v, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %q is not a boolean", p.Name, raw)
+		}
+		return v, nil
+	case ParamEnum:
+		if !slices.Contains(p.Enum, raw) {
+			return nil, fmt.Errorf("parameter %s: %q is not one of %s", p.Name, raw, strings.Join(p.Enum, ", "))
+		}
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("parameter %s: unknown type %q", p.Name, p.Type)
+	}
+}
+
+// ResolveParams validates values against the schema and returns them typed,
+// with defaults filled in for the parameters that were not set.
+func ResolveParams(schema []TemplateParameter, values map[string]string) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(schema))
+	known := make(map[string]bool, len(schema))
+
+	var errs []error
+
+	for _, p := range schema {
+		known[p.Name] = true
+
+		raw, ok := values[p.Name]
+		if !ok && p.Default != nil {
+			raw, ok = fmt.Sprint(p.Default), true
+		}
+
+		if !ok {
+			if p.Required {
+				errs = append(errs, fmt.Errorf("parameter %s is required", p.Name))
+			}
+			continue
+		}
+
+		v, err := p.convert(raw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		resolved[p.Name] = v
+	}
+
+	unknown := make([]string, 0)
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, name := range unknown {
+		errs = append(errs, fmt.Errorf("unknown parameter %s", name))
+	}
+
+	return resolved, errors.Join(errs...)
+}
+
+// ParseParam splits a key=value command line parameter.
+func ParseParam(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return "", "", fmt.Errorf("invalid parameter %q, expected key=value", s)
+	}
+
+	return strings.TrimSpace(key), value, nil
+}
+
+// mergeParameters returns base with the parameters of overlay added, those
+// with the same name replacing the base ones.
+func mergeParameters(base, overlay []TemplateParameter) []TemplateParameter {
+	merged := append([]TemplateParameter{}, base...)
+
+	for _, p := range overlay {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == p.Name {
+				merged[i] = p
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, p)
+		}
+	}
+
+	return merged
+}
+
+// paramsPrompt describes the parameter values for the system prompt.
+func paramsPrompt(params map[string]interface{}) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("Project parameters:")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n- %s: %v", name, params[name])
+	}
+
+	return b.String()
+}
@@ -1,40 +1,79 @@
 package agents
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"regexp"
 	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/tracing"
 )
 
-func (a *Agent) ParserCode(content string) error {
+var (
+	codeBlockRegex  = regexp.MustCompile(`(?s)---FILE_PATH: (.+?)\n(.*?)---END_FILE`)
+	fenceOpenRegex  = regexp.MustCompile("^```[a-zA-Z0-9]*\n")
+	fenceCloseRegex = regexp.MustCompile("\n```$")
+)
 
-	codeBlockRegex := regexp.MustCompile(`(?s)---FILE_PATH: (.+?)\n(.*?)---END_FILE`)
+// ParsedFile is a file found in a model reply.
+type ParsedFile struct {
+	Path    string
+	Content string
+}
 
+// ParseFiles returns the files of a model reply, in the order they appear,
+// with markdown code fences removed.
+func ParseFiles(content string) []ParsedFile {
 	matches := codeBlockRegex.FindAllStringSubmatch(content, -1)
 
-	if len(matches) == 0 {
-		log.Printf("Could not finde FILE_PATH in codeblock")
-
-		return nil
-	}
+	files := make([]ParsedFile, 0, len(matches))
 
 	for _, match := range matches {
 		if len(match) < 3 {
-			log.Printf("Invalid match found: %v", match)
+			slog.Debug("Invalid match found", "match", match)
 			continue
 		}
 
 		filePath := strings.TrimSpace(match[1])
 		code := strings.TrimSpace(match[2])
 
-		code = regexp.MustCompile("^```[a-zA-Z0-9]*\n").ReplaceAllString(code, "")
-		code = regexp.MustCompile("\n```$").ReplaceAllString(code, "")
+		code = fenceOpenRegex.ReplaceAllString(code, "")
+		code = fenceCloseRegex.ReplaceAllString(code, "")
 
-		a.taskQueue <- fileTask{
-			Path:    filePath,
-			Content: code,
+		files = append(files, ParsedFile{Path: filePath, Content: code})
+	}
+
+	return files
+}
+
+func (a *Agent) ParserCode(content string) error {
+	return a.parseCode(a.ctx, content)
+}
+
+// parseCode queues the files of a model reply, traced under the span in
+// ctx.
+func (a *Agent) parseCode(ctx context.Context, content string) error {
+	ctx, span := tracing.Start(ctx, "parse", "bytes", len(content))
+	defer span.End()
+
+	files := ParseFiles(content)
+	span.SetAttributes("files", len(files))
+
+	if len(files) == 0 {
+		a.logger.Warn("Could not find FILE_PATH in the model reply")
+		if a.progressCallBack != nil {
+			a.progressCallBack("warning", "The model reply contains no files", "")
 		}
 
+		return nil
+	}
+
+	for _, file := range files {
+		a.taskQueue <- fileTask{
+			Path:    file.Path,
+			Content: file.Content,
+			ctx:     ctx,
+		}
 	}
 
 	return nil
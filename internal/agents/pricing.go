@@ -0,0 +1,41 @@
+package agents
+
+import "strings"
+
+// ModelPrice is the price in USD per one million tokens.
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+var modelPrices = map[string]ModelPrice{
+	"gpt-4o":       {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":  {Input: 0.15, Output: 0.60},
+	"gpt-4.1":      {Input: 2.00, Output: 8.00},
+	"gpt-4.1-mini": {Input: 0.40, Output: 1.60},
+	"o1":           {Input: 15.00, Output: 60.00},
+	"o3-mini":      {Input: 1.10, Output: 4.40},
+	"o4-mini":      {Input: 1.10, Output: 4.40},
+}
+
+// EstimateCost returns the approximate cost in USD of the given usage.
+// Unknown models are priced as zero.
+func EstimateCost(model string, usage Usage) float64 {
+	price, ok := modelPrices[model]
+
+	if !ok {
+		// dated snapshots like gpt-4o-mini-2024-07-18 share the base price
+		longest := 0
+		for name, p := range modelPrices {
+			if strings.HasPrefix(model, name+"-") && len(name) > longest {
+				price, ok, longest = p, true, len(name)
+			}
+		}
+	}
+
+	if !ok {
+		return 0
+	}
+
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1_000_000
+}
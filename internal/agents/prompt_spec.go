@@ -0,0 +1,184 @@
+package agents
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+// maxContextSize is the largest context file sent with a prompt.
+const maxContextSize = 256 << 10
+
+// PromptSpec is a generation checked in as a YAML file: the template, its
+// parameters, the context files and the prompt, so the same project can be
+// generated again.
+type PromptSpec struct {
+	Template    string                 `yaml:"template"`
+	Language    string                 `yaml:"language"`
+	BasePackage string                 `yaml:"base_package"`
+	ProjectName string                 `yaml:"project_name"`
+	Model       string                 `yaml:"model"`
+	Addons      []string               `yaml:"addons"`
+	Params      map[string]interface{} `yaml:"params"`
+	// Context are files or glob patterns, relative to the spec, sent along
+	// with the prompt.
+	Context []string `yaml:"context"`
+	// Prompt is the prompt text. PromptFile, relative to the spec, can hold
+	// it instead, e.g. a Markdown requirements document.
+	Prompt     string `yaml:"prompt"`
+	PromptFile string `yaml:"prompt_file"`
+
+	// Dir is the directory of the spec file.
+	Dir string `yaml:"-"`
+}
+
+// ContextFile is a file sent with the prompt as part of the requirements.
+type ContextFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// LoadPromptSpec reads the spec at path and the prompt file it points to.
+func LoadPromptSpec(path string) (*PromptSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec PromptSpec
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	spec.Dir = filepath.Dir(path)
+
+	if spec.PromptFile != "" {
+		if spec.Prompt != "" {
+			return nil, fmt.Errorf("%s: set either prompt or prompt_file", path)
+		}
+
+		content, err := os.ReadFile(filepath.Join(spec.Dir, spec.PromptFile))
+		if err != nil {
+			return nil, fmt.Errorf("%s: prompt_file: %w", path, err)
+		}
+		spec.Prompt = string(content)
+	}
+
+	return &spec, nil
+}
+
+// ParamValues returns the parameters of the spec as raw values, like those
+// given with -param.
+func (s *PromptSpec) ParamValues() map[string]string {
+	values := make(map[string]string, len(s.Params))
+	for name, value := range s.Params {
+		values[name] = fmt.Sprint(value)
+	}
+
+	return values
+}
+
+// LoadContextFiles reads the files matching patterns, relative to dir.
+// Directories are read recursively, skipping hidden ones and binary files.
+func LoadContextFiles(dir string, patterns []string) ([]ContextFile, error) {
+	var files []ContextFile
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("context %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("context %s matches no file", pattern)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if d.IsDir() {
+					if p != match && strings.HasPrefix(d.Name(), ".") {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				rel, err := filepath.Rel(dir, p)
+				if err != nil {
+					rel = p
+				}
+				rel = filepath.ToSlash(rel)
+				if seen[rel] {
+					return nil
+				}
+				seen[rel] = true
+
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				if info.Size() > maxContextSize {
+					return fmt.Errorf("context file %s is larger than %d KB", rel, maxContextSize>>10)
+				}
+
+				data, err := os.ReadFile(p)
+				if err != nil {
+					return err
+				}
+				if !isText(data) {
+					slog.Debug("Skipping binary context file", logging.FileKey, rel)
+					return nil
+				}
+
+				files = append(files, ContextFile{Path: rel, Content: string(data)})
+
+				return nil
+			})
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// SetContextFiles sets the files sent with the prompt.
+func (a *Agent) SetContextFiles(files []ContextFile) {
+	a.contextFiles = files
+}
+
+// formatContext lists the context files after the prompt, in a format
+// distinct from the file blocks.
+func formatContext(files []ContextFile) string {
+	var b strings.Builder
+
+	b.WriteString("The following files are part of the requirements:\n")
+
+	for _, file := range files {
+		fmt.Fprintf(&b, "\n---CONTEXT: %s\n%s\n---END_CONTEXT\n", file.Path, strings.TrimSpace(file.Content))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package agents
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Reasoning efforts of the o-series models.
+const (
+	EffortLow    = "low"
+	EffortMedium = "medium"
+	EffortHigh   = "high"
+)
+
+// maxStopSequences is how many stop sequences the API accepts.
+const maxStopSequences = 4
+
+// SamplingParams are the generation parameters sent with each request.
+// Unset fields are left out of the request, so the API defaults apply.
+// Pointers tell an unset value from an explicit zero, like a temperature
+// of 0 or a seed of 0.
+type SamplingParams struct {
+	Temperature *float64 `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty" yaml:"top_p,omitempty"`
+	// MaxTokens limits the tokens of the response. It is sent as
+	// max_completion_tokens to reasoning models, where it also counts the
+	// reasoning tokens.
+	MaxTokens int `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	// Seed makes sampling deterministic, as far as the API allows: requests
+	// with the same seed and parameters mostly return the same response.
+	Seed *int     `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Stop []string `json:"stop,omitempty" yaml:"stop,omitempty"`
+	// ReasoningEffort is low, medium or high. Only reasoning models use it.
+	ReasoningEffort string `json:"reasoning_effort,omitempty" yaml:"reasoning_effort,omitempty"`
+}
+
+// IsZero reports whether no parameter is set.
+func (p SamplingParams) IsZero() bool {
+	return p.Temperature == nil && p.TopP == nil && p.MaxTokens == 0 && p.Seed == nil && len(p.Stop) == 0 && p.ReasoningEffort == ""
+}
+
+// Validate checks the parameters are within the ranges of the API.
+func (p SamplingParams) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature %v is not between 0 and 2", *p.Temperature)
+	}
+
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p %v is not greater than 0 and at most 1", *p.TopP)
+	}
+
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens %d is negative", p.MaxTokens)
+	}
+
+	if len(p.Stop) > maxStopSequences {
+		return fmt.Errorf("%d stop sequences, at most %d are allowed", len(p.Stop), maxStopSequences)
+	}
+
+	switch p.ReasoningEffort {
+	case "", EffortLow, EffortMedium, EffortHigh:
+	default:
+		return fmt.Errorf("reasoning effort %q is not one of %s, %s or %s", p.ReasoningEffort, EffortLow, EffortMedium, EffortHigh)
+	}
+
+	return nil
+}
+
+// Merge returns p with the parameters set in over replacing its own.
+func (p SamplingParams) Merge(over SamplingParams) SamplingParams {
+	merged := p
+
+	if over.Temperature != nil {
+		merged.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		merged.TopP = over.TopP
+	}
+	if over.MaxTokens != 0 {
+		merged.MaxTokens = over.MaxTokens
+	}
+	if over.Seed != nil {
+		merged.Seed = over.Seed
+	}
+	if len(over.Stop) > 0 {
+		merged.Stop = over.Stop
+	}
+	if over.ReasoningEffort != "" {
+		merged.ReasoningEffort = over.ReasoningEffort
+	}
+
+	return merged
+}
+
+var reasoningModel = regexp.MustCompile(`^o\d`)
+
+// IsReasoningModel reports whether model is an o-series reasoning model.
+// Those take max_completion_tokens and reasoning_effort, and reject
+// temperature, top_p and stop.
+func IsReasoningModel(model string) bool {
+	return reasoningModel.MatchString(strings.ToLower(model))
+}
+
+// requestFields adds the parameters to the request body for model. It
+// returns the parameters the model does not support, which are left out.
+func (p SamplingParams) requestFields(model string, body map[string]interface{}) (ignored []string) {
+	reasoning := IsReasoningModel(model)
+
+	if p.Seed != nil {
+		body["seed"] = *p.Seed
+	}
+
+	if p.MaxTokens > 0 {
+		if reasoning {
+			body["max_completion_tokens"] = p.MaxTokens
+		} else {
+			body["max_tokens"] = p.MaxTokens
+		}
+	}
+
+	if reasoning {
+		if p.ReasoningEffort != "" {
+			body["reasoning_effort"] = p.ReasoningEffort
+		}
+		if p.Temperature != nil {
+			ignored = append(ignored, "temperature")
+		}
+		if p.TopP != nil {
+			ignored = append(ignored, "top_p")
+		}
+		if len(p.Stop) > 0 {
+			ignored = append(ignored, "stop")
+		}
+		return ignored
+	}
+
+	if p.Temperature != nil {
+		body["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		body["top_p"] = *p.TopP
+	}
+	if len(p.Stop) > 0 {
+		body["stop"] = p.Stop
+	}
+	if p.ReasoningEffort != "" {
+		ignored = append(ignored, "reasoning_effort")
+	}
+
+	return ignored
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	formatZip   = "zip"
+	formatTarGz = "tar.gz"
+)
+
+// archiveFile is a file of the project that goes into an archive.
+type archiveFile struct {
+	fullPath string
+	relPath  string
+	info     fs.FileInfo
+}
+
+// collectArchiveFiles walks dir and returns the files matching paths, which
+// are slash separated files or directories relative to dir. An empty paths
+// selects everything.
+func collectArchiveFiles(dir string, paths []string) ([]archiveFile, error) {
+	var files []archiveFile
+
+	err := filepath.Walk(dir, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		relPath = filepath.ToSlash(relPath)
+
+		if !matchesPaths(relPath, paths) {
+			return nil
+		}
+
+		files = append(files, archiveFile{
+			fullPath: p,
+			relPath:  relPath,
+			info:     info,
+		})
+
+		return nil
+	})
+
+	return files, err
+}
+
+func matchesPaths(relPath string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+
+	for _, p := range paths {
+		if relPath == p || strings.HasPrefix(relPath, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeZip streams files into a zip archive under the root folder.
+func writeZip(w io.Writer, root string, files []archiveFile) error {
+	archive := zip.NewWriter(w)
+
+	for _, f := range files {
+		header, err := zip.FileInfoHeader(f.info)
+		if err != nil {
+			return err
+		}
+
+		header.Name = path.Join(root, f.relPath)
+		header.Method = zip.Deflate
+
+		entry, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if err := copyFile(entry, f.fullPath); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// writeTarGz streams files into a gzip compressed tarball under the root
+// folder, keeping their permission bits.
+func writeTarGz(w io.Writer, root string, files []archiveFile) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	for _, f := range files {
+		header, err := tar.FileInfoHeader(f.info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = path.Join(root, f.relPath)
+		header.Uname = ""
+		header.Gname = ""
+		header.Uid = 0
+		header.Gid = 0
+
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if err := copyFile(archive, f.fullPath); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func copyFile(w io.Writer, fullPath string) error {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+
+	return err
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+)
+
+// catalogReady fails the request when templates could not be loaded.
+func (s *Server) catalogReady(w http.ResponseWriter) bool {
+	if s.agent == nil {
+		http.Error(w, "Templates not available", http.StatusServiceUnavailable)
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// TemplateSummary is what the catalog endpoints tell about a template or an
+// add-on. File contents, system prompts and examples stay on the server.
+type TemplateSummary struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Language    string                     `json:"language"`
+	Parameters  []agents.TemplateParameter `json:"parameters,omitempty"`
+}
+
+func summarize(tmpl agents.ProjectTemplate) TemplateSummary {
+	return TemplateSummary{
+		Name:        tmpl.Name,
+		Description: tmpl.Description,
+		Language:    tmpl.Language,
+		Parameters:  tmpl.Parameters,
+	}
+}
+
+// HandleTemplates lists the available templates, with their parents and
+// mixins resolved, optionally only those of the language given in the
+// language query parameter.
+func (s *Server) HandleTemplates(w http.ResponseWriter, r *http.Request) {
+	if !s.catalogReady(w) {
+		return
+	}
+
+	language := strings.ToLower(r.URL.Query().Get("language"))
+
+	templates := []TemplateSummary{}
+	for _, tmpl := range s.agent.ListTemplates() {
+		if resolved, err := s.agent.ResolveTemplate(tmpl.Name); err == nil {
+			tmpl = resolved
+		}
+
+		if language != "" && strings.ToLower(tmpl.Language) != language {
+			continue
+		}
+		templates = append(templates, summarize(tmpl))
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	writeJSON(w, templates)
+}
+
+func (s *Server) HandleAddons(w http.ResponseWriter, r *http.Request) {
+	if !s.catalogReady(w) {
+		return
+	}
+
+	addons := []TemplateSummary{}
+	for _, addon := range s.agent.ListAddons() {
+		addons = append(addons, summarize(addon))
+	}
+	sort.Slice(addons, func(i, j int) bool {
+		return addons[i].Name < addons[j].Name
+	})
+
+	writeJSON(w, addons)
+}
+
+func (s *Server) HandleLanguages(w http.ResponseWriter, r *http.Request) {
+	if !s.catalogReady(w) {
+		return
+	}
+
+	languages := s.agent.Listlanguages()
+	sort.Strings(languages)
+
+	writeJSON(w, languages)
+}
+
+func (s *Server) HandleModels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, agents.Models)
+}
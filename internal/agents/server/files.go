@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// fileLanguages maps extensions to the language names understood by the
+// web UI syntax highlighter.
+var fileLanguages = map[string]string{
+	".go":         "go",
+	".mod":        "go",
+	".py":         "python",
+	".js":         "javascript",
+	".mjs":        "javascript",
+	".cjs":        "javascript",
+	".ts":         "typescript",
+	".jsx":        "javascript",
+	".tsx":        "typescript",
+	".java":       "java",
+	".kt":         "kotlin",
+	".gradle":     "groovy",
+	".json":       "json",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".toml":       "ini",
+	".ini":        "ini",
+	".cfg":        "ini",
+	".xml":        "xml",
+	".html":       "xml",
+	".css":        "css",
+	".md":         "markdown",
+	".sh":         "bash",
+	".sql":        "sql",
+	".properties": "properties",
+	".txt":        "plaintext",
+	".env":        "bash",
+}
+
+var fileNameLanguages = map[string]string{
+	"Makefile":   "makefile",
+	"Dockerfile": "dockerfile",
+}
+
+type FileEntry struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Language string `json:"language"`
+}
+
+type FileTree struct {
+	SessionID string      `json:"sessionId"`
+	Project   string      `json:"project"`
+	Files     []FileEntry `json:"files"`
+}
+
+func languageForFile(name string) string {
+	base := path.Base(name)
+
+	if lang, ok := fileNameLanguages[base]; ok {
+		return lang
+	}
+
+	if lang, ok := fileLanguages[strings.ToLower(path.Ext(base))]; ok {
+		return lang
+	}
+
+	return "plaintext"
+}
+
+// sessionProject returns the session directory and the name of the project
+// generated inside it.
+func (s *Server) sessionProject(sessionID string) (string, string, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return "", "", ErrSessionNotFound
+	}
+
+	sessionDir := filepath.Join(s.outputBase, sessionID)
+
+	entries, err := os.ReadDir(sessionDir)
+	if err != nil {
+		return "", "", ErrSessionNotFound
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return sessionDir, entry.Name(), nil
+		}
+	}
+
+	return "", "", ErrSessionNotFound
+}
+
+func listProjectFiles(projectDir string) ([]FileEntry, error) {
+	files := []FileEntry{}
+
+	err := filepath.WalkDir(projectDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(projectDir, p)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		files = append(files, FileEntry{
+			Path:     rel,
+			Size:     info.Size(),
+			Language: languageForFile(rel),
+		})
+
+		return nil
+	})
+
+	return files, err
+}
+
+// HandleFiles serves /api/sessions/{id}/files, listing the generated files,
+// and /api/sessions/{id}/files/{path}, returning the content of one file.
+func (s *Server) HandleFiles(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+
+	sessionID, resource, _ := strings.Cut(rest, "/")
+
+	if resource != "files" && !strings.HasPrefix(resource, "files/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	sessionDir, project, err := s.sessionProject(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	projectDir := filepath.Join(sessionDir, project)
+	filePath := strings.TrimPrefix(strings.TrimPrefix(resource, "files"), "/")
+
+	if filePath == "" {
+		files, err := listProjectFiles(projectDir)
+		if err != nil {
+			http.Error(w, "Could not list files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FileTree{
+			SessionID: sessionID,
+			Project:   project,
+			Files:     files,
+		})
+		return
+	}
+
+	if !fs.ValidPath(filePath) {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	fullPath := filepath.Join(projectDir, filepath.FromSlash(filePath))
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(filePath))
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(file, head)
+		contentType = http.DetectContentType(head[:n])
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "Could not read file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if strings.HasPrefix(contentType, "text/html") {
+		// never render generated pages as part of our origin
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-File-Language", languageForFile(filePath))
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
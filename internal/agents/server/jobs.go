@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
+)
+
+const (
+	eventLogFile = "events.jsonl"
+
+	// subscriberBuffer is how many events a slow client can fall behind
+	// before it is disconnected and has to reconnect.
+	subscriberBuffer = 256
+)
+
+// job is a generation running on the server. Every event it produces gets
+// a sequence number and is kept in an ordered log, in memory while the job
+// runs and in the session directory afterwards, so clients can reconnect and
+// replay what they missed.
+type job struct {
+	id          string
+	sessionDir  string
+	projectName string
+	projectDir  string
+	request     ProjectRequest
+	clientKey   string
+	reservation *reservation
+	baseURL     string
+	started     time.Time
+	mu          sync.Mutex
+	agent       *agents.Agent
+	events      []ProgressEvent
+	subscribers map[chan ProgressEvent]struct{}
+	done        bool
+	logFile     *os.File
+	logger      *slog.Logger
+	// ctx carries the span of the generation, span.
+	ctx  context.Context
+	span *tracing.Span
+}
+
+// newJob returns the job of a session. Its logger carries the session ID.
+func newJob(id, sessionDir, projectName string, req ProjectRequest, logger *slog.Logger) *job {
+	j := &job{
+		id:          id,
+		sessionDir:  sessionDir,
+		projectName: projectName,
+		projectDir:  filepath.Join(sessionDir, projectName),
+		request:     req,
+		started:     time.Now(),
+		subscribers: make(map[chan ProgressEvent]struct{}),
+		logger:      logger.With(logging.SessionKey, id),
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(sessionDir, eventLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		j.logger.Error("Could not open event log", logging.ErrorKey, err)
+	} else {
+		j.logFile = logFile
+	}
+
+	return j
+}
+
+func (j *job) setAgent(agent *agents.Agent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.agent = agent
+}
+
+func (j *job) queueDepth() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.agent == nil {
+		return 0
+	}
+
+	return j.agent.QueueDepth()
+}
+
+// publish numbers the event, records it and fans it out to subscribers.
+func (j *job) publish(event ProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.done {
+		return
+	}
+
+	event.Seq = len(j.events) + 1
+	event.SessionID = j.id
+	j.events = append(j.events, event)
+
+	if j.logFile != nil {
+		if err := json.NewEncoder(j.logFile).Encode(event); err != nil {
+			j.logger.Error("Error writing event log", logging.ErrorKey, err)
+		}
+	}
+
+	for ch := range j.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// too far behind, the client will reconnect and replay
+			delete(j.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the events after lastSeq and a channel with the ones
+// still to come. The channel is nil when the job already finished.
+func (j *job) subscribe(lastSeq int) ([]ProgressEvent, chan ProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var backlog []ProgressEvent
+	if lastSeq < len(j.events) {
+		backlog = append(backlog, j.events[max(lastSeq, 0):]...)
+	}
+
+	if j.done {
+		return backlog, nil
+	}
+
+	ch := make(chan ProgressEvent, subscriberBuffer)
+	j.subscribers[ch] = struct{}{}
+
+	return backlog, ch
+}
+
+func (j *job) unsubscribe(ch chan ProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.subscribers[ch]; ok {
+		delete(j.subscribers, ch)
+		close(ch)
+	}
+}
+
+// finish closes the event stream of the job.
+func (j *job) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.done = true
+
+	for ch := range j.subscribers {
+		delete(j.subscribers, ch)
+		close(ch)
+	}
+
+	if j.logFile != nil {
+		j.logFile.Close()
+	}
+}
+
+// readEventLog returns the events after lastSeq recorded for a finished
+// session.
+func readEventLog(sessionDir string, lastSeq int) ([]ProgressEvent, error) {
+	file, err := os.Open(filepath.Join(sessionDir, eventLogFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []ProgressEvent
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var event ProgressEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return events, err
+		}
+
+		if event.Seq > lastSeq {
+			events = append(events, event)
+		}
+	}
+
+	return events, scanner.Err()
+}
+
+func (s *Server) addJob(j *job) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	s.jobs[j.id] = j
+}
+
+func (s *Server) removeJob(id string) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	delete(s.jobs, id)
+}
+
+func (s *Server) getJob(id string) (*job, bool) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	j, ok := s.jobs[id]
+
+	return j, ok
+}
+
+// jobStats returns the pending file tasks across all running jobs and the
+// number of running jobs.
+func (s *Server) jobStats() (queueDepth int, active int) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	for _, j := range s.jobs {
+		queueDepth += j.queueDepth()
+	}
+
+	return queueDepth, len(s.jobs)
+}
+
+// streamJob sends the events of j after lastSeq to the client until the job
+// finishes or the client goes away.
+func streamJob(client *WebSocketClient, j *job, lastSeq int) {
+	backlog, ch := j.subscribe(lastSeq)
+
+	for _, event := range backlog {
+		if err := client.WriteJSON(event); err != nil {
+			if ch != nil {
+				j.unsubscribe(ch)
+			}
+			return
+		}
+	}
+
+	if ch == nil {
+		return
+	}
+	defer j.unsubscribe(ch)
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := client.conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := client.WriteJSON(event); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+)
+
+// Limits bounds what a single client can ask from the server. Zero values
+// disable the corresponding limit.
+type Limits struct {
+	RequestsPerMinute int
+	DailyTokenBudget  int
+	DailyCostBudget   float64
+	MaxPromptLength   int
+	MaxWorkerCount    int
+	TrustProxy        bool
+}
+
+const defaultWorkerCount = 4
+
+// reservedUsage is what a generation is expected to use before the server
+// has seen any finish. Afterwards the average of the finished ones is used.
+var reservedUsage = agents.Usage{PromptTokens: 15000, CompletionTokens: 5000, TotalTokens: 20000}
+
+// LimitError is returned when a client exceeds one of the server limits.
+// ResetAt is zero for limits that do not reset over time.
+type LimitError struct {
+	Reason  string
+	ResetAt time.Time
+}
+
+func (e *LimitError) Error() string {
+	if e.ResetAt.IsZero() {
+		return e.Reason
+	}
+
+	return fmt.Sprintf("%s, resets at %s", e.Reason, e.ResetAt.Format(time.RFC3339))
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// dailyBudget is what a client used in a day. reservedTokens and
+// reservedCost are held by the generations still running, so concurrent
+// ones cannot all pass the check before any of them is charged.
+type dailyBudget struct {
+	day            time.Time
+	tokens         int
+	cost           float64
+	reservedTokens int
+	reservedCost   float64
+}
+
+// reservation is the share of a daily budget held by a running generation,
+// settled with its actual usage when it ends.
+type reservation struct {
+	key    string
+	model  string
+	day    time.Time
+	tokens int
+	cost   float64
+}
+
+type limiter struct {
+	limits  Limits
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+	budgets map[string]*dailyBudget
+	day     time.Time
+	// finished and used give the average usage of a generation.
+	finished int
+	used     agents.Usage
+	now      func() time.Time
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:  limits,
+		windows: make(map[string]*rateWindow),
+		budgets: make(map[string]*dailyBudget),
+		now:     time.Now,
+	}
+}
+
+// clientKey identifies the caller of a request, by default its remote IP.
+// Behind a trusted proxy it is the last X-Forwarded-For address, the one the
+// proxy appended: the ones before it come from the client and can be forged.
+func (l *limiter) clientKey(r *http.Request) string {
+	if l.limits.TrustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+			if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+				return addr
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// checkRequest validates the request shape and fills in defaults.
+func (l *limiter) checkRequest(req *ProjectRequest) error {
+	if length := utf8.RuneCountInString(req.Prompt); l.limits.MaxPromptLength > 0 && length > l.limits.MaxPromptLength {
+		return &LimitError{
+			Reason: fmt.Sprintf("prompt is %d characters long, maximum is %d", length, l.limits.MaxPromptLength),
+		}
+	}
+
+	if req.WorkerCount <= 0 {
+		req.WorkerCount = defaultWorkerCount
+		if l.limits.MaxWorkerCount > 0 && req.WorkerCount > l.limits.MaxWorkerCount {
+			req.WorkerCount = l.limits.MaxWorkerCount
+		}
+	}
+
+	if l.limits.MaxWorkerCount > 0 && req.WorkerCount > l.limits.MaxWorkerCount {
+		return &LimitError{
+			Reason: fmt.Sprintf("workerCount %d exceeds maximum of %d", req.WorkerCount, l.limits.MaxWorkerCount),
+		}
+	}
+
+	return nil
+}
+
+// allow registers a new generation of model for key and reports whether it
+// fits in the per minute rate and the daily budgets. The expected usage of
+// the generation is reserved from the budgets until settle is called with
+// the returned reservation.
+func (l *limiter) allow(key, model string) (*reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.pruneBudgets(now)
+
+	budget := l.budget(key, now)
+	if budget != nil {
+		resetAt := budget.day.Add(24 * time.Hour)
+
+		if l.limits.DailyTokenBudget > 0 && budget.tokens+budget.reservedTokens >= l.limits.DailyTokenBudget {
+			return nil, &LimitError{
+				Reason:  fmt.Sprintf("daily token budget of %d exhausted", l.limits.DailyTokenBudget),
+				ResetAt: resetAt,
+			}
+		}
+
+		if l.limits.DailyCostBudget > 0 && budget.cost+budget.reservedCost >= l.limits.DailyCostBudget {
+			return nil, &LimitError{
+				Reason:  fmt.Sprintf("daily budget of $%.2f exhausted", l.limits.DailyCostBudget),
+				ResetAt: resetAt,
+			}
+		}
+	}
+
+	if err := l.allowRate(key, now); err != nil {
+		return nil, err
+	}
+
+	if l.limits.DailyTokenBudget <= 0 && l.limits.DailyCostBudget <= 0 {
+		return nil, nil
+	}
+
+	if budget == nil {
+		budget = &dailyBudget{day: startOfDay(now)}
+		l.budgets[key] = budget
+	}
+
+	expected := l.expectedUsage()
+	res := &reservation{
+		key:    key,
+		model:  model,
+		day:    budget.day,
+		tokens: expected.TotalTokens,
+		cost:   agents.EstimateCost(model, expected),
+	}
+	budget.reservedTokens += res.tokens
+	budget.reservedCost += res.cost
+
+	return res, nil
+}
+
+// allowRate counts a request of key in its per minute window. Callers must
+// hold l.mu.
+func (l *limiter) allowRate(key string, now time.Time) error {
+	if l.limits.RequestsPerMinute <= 0 {
+		return nil
+	}
+
+	if len(l.windows) > 1024 {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= time.Minute {
+				delete(l.windows, k)
+			}
+		}
+	}
+
+	window, ok := l.windows[key]
+	if !ok || now.Sub(window.start) >= time.Minute {
+		window = &rateWindow{start: now}
+		l.windows[key] = window
+	}
+
+	if window.count >= l.limits.RequestsPerMinute {
+		return &LimitError{
+			Reason:  fmt.Sprintf("rate limit of %d requests per minute exceeded", l.limits.RequestsPerMinute),
+			ResetAt: window.start.Add(time.Minute),
+		}
+	}
+
+	window.count++
+
+	return nil
+}
+
+// settle releases a reservation and charges the actual usage of the
+// generation in its place. Generations that did not start settle with no
+// usage. A nil reservation, when no budget is set, is ignored.
+func (l *limiter) settle(res *reservation, usage agents.Usage) {
+	if res == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if usage.TotalTokens > 0 {
+		l.finished++
+		l.used.Add(usage)
+	}
+
+	now := l.now()
+
+	// a reservation from a previous day went with that day's budget
+	if budget, ok := l.budgets[res.key]; ok && budget.day.Equal(res.day) {
+		budget.reservedTokens -= res.tokens
+		budget.reservedCost -= res.cost
+	}
+
+	if usage.TotalTokens == 0 {
+		return
+	}
+
+	budget := l.budget(res.key, now)
+	if budget == nil {
+		budget = &dailyBudget{day: startOfDay(now)}
+		l.budgets[res.key] = budget
+	}
+
+	budget.tokens += usage.TotalTokens
+	budget.cost += agents.EstimateCost(res.model, usage)
+}
+
+// expectedUsage is the usage reserved for a new generation. Callers must
+// hold l.mu.
+func (l *limiter) expectedUsage() agents.Usage {
+	if l.finished == 0 {
+		return reservedUsage
+	}
+
+	return agents.Usage{
+		PromptTokens:     l.used.PromptTokens / l.finished,
+		CompletionTokens: l.used.CompletionTokens / l.finished,
+		TotalTokens:      l.used.TotalTokens / l.finished,
+	}
+}
+
+// budget returns the budget of key for the current day. Callers must hold
+// l.mu.
+func (l *limiter) budget(key string, now time.Time) *dailyBudget {
+	budget, ok := l.budgets[key]
+	if !ok || !budget.day.Equal(startOfDay(now)) {
+		return nil
+	}
+
+	return budget
+}
+
+// pruneBudgets drops the budgets of previous days once a new day starts.
+// Callers must hold l.mu.
+func (l *limiter) pruneBudgets(now time.Time) {
+	today := startOfDay(now)
+	if l.day.Equal(today) {
+		return
+	}
+
+	l.day = today
+	for key, budget := range l.budgets {
+		if !budget.day.Equal(today) {
+			delete(l.budgets, key)
+		}
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
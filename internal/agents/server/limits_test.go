@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+)
+
+func TestClientKeyUsesProxyAddress(t *testing.T) {
+	l := newLimiter(Limits{TrustProxy: true})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+
+	if got := l.clientKey(r); got != "5.6.7.8" {
+		t.Errorf("clientKey = %s, want the address the proxy added", got)
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	if got := l.clientKey(r); got != "10.0.0.2" {
+		t.Errorf("clientKey = %s, want the remote address", got)
+	}
+}
+
+func TestCheckRequestCountsCharacters(t *testing.T) {
+	l := newLimiter(Limits{MaxPromptLength: 5})
+
+	if err := l.checkRequest(&ProjectRequest{Prompt: "ñandú"}); err != nil {
+		t.Errorf("5 characters refused: %v", err)
+	}
+	if err := l.checkRequest(&ProjectRequest{Prompt: "ñandús"}); err == nil {
+		t.Error("6 characters accepted")
+	}
+}
+
+func TestAllowReservesTokenBudget(t *testing.T) {
+	l := newLimiter(Limits{DailyTokenBudget: 30000})
+
+	first, err := l.allow("c", "gpt-4o-mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.allow("c", "gpt-4o-mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both running generations hold the budget
+	if _, err := l.allow("c", "gpt-4o-mini"); err == nil || !strings.Contains(err.Error(), "exhausted") {
+		t.Fatalf("err = %v, want the budget exhausted", err)
+	}
+
+	l.settle(first, agents.Usage{})
+	l.settle(second, agents.Usage{TotalTokens: 1000, PromptTokens: 800, CompletionTokens: 200})
+
+	if _, err := l.allow("c", "gpt-4o-mini"); err != nil {
+		t.Errorf("budget not released: %v", err)
+	}
+	if got := l.budgets["c"].tokens; got != 1000 {
+		t.Errorf("tokens = %d, want 1000", got)
+	}
+}
+
+func TestAllowPrunesPreviousDays(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(Limits{DailyTokenBudget: 1000})
+	l.now = func() time.Time { return now }
+
+	res, err := l.allow("old", "gpt-4o-mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(24 * time.Hour)
+
+	if _, err := l.allow("new", "gpt-4o-mini"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.budgets["old"]; ok {
+		t.Error("budget of a previous day kept")
+	}
+
+	// settling yesterday's reservation charges today's budget
+	l.settle(res, agents.Usage{TotalTokens: 10})
+	if got := l.budgets["old"]; got == nil || got.tokens != 10 || got.reservedTokens != 0 {
+		t.Errorf("budget = %+v", got)
+	}
+}
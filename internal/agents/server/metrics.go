@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+)
+
+var latencyBuckets = []float64{5, 10, 30, 60, 120, 300, 600, 1200}
+
+type generationLabels struct {
+	template string
+	model    string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// metrics holds the counters exported on /metrics in the Prometheus text
+// exposition format.
+type metrics struct {
+	mu                sync.Mutex
+	started           map[generationLabels]uint64
+	completed         map[generationLabels]uint64
+	failed            map[generationLabels]uint64
+	latency           map[generationLabels]*histogram
+	tokens            map[string]map[string]uint64
+	filesWritten      uint64
+	upstreamErrors    uint64
+	activeConnections int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		started:   make(map[generationLabels]uint64),
+		completed: make(map[generationLabels]uint64),
+		failed:    make(map[generationLabels]uint64),
+		latency:   make(map[generationLabels]*histogram),
+		tokens:    make(map[string]map[string]uint64),
+	}
+}
+
+func (m *metrics) connectionOpened() {
+	m.mu.Lock()
+	m.activeConnections++
+	m.mu.Unlock()
+}
+
+func (m *metrics) connectionClosed() {
+	m.mu.Lock()
+	m.activeConnections--
+	m.mu.Unlock()
+}
+
+func (m *metrics) generationStarted(template, model string) {
+	m.mu.Lock()
+	m.started[generationLabels{template, model}]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) generationFinished(template, model string, elapsed time.Duration, files int, usage agents.Usage, err error) {
+	labels := generationLabels{template, model}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.failed[labels]++
+	} else {
+		m.completed[labels]++
+	}
+
+	h, ok := m.latency[labels]
+	if !ok {
+		h = &histogram{}
+		m.latency[labels] = h
+	}
+	h.observe(elapsed.Seconds())
+
+	m.filesWritten += uint64(files)
+
+	if _, ok := m.tokens[model]; !ok {
+		m.tokens[model] = make(map[string]uint64)
+	}
+	m.tokens[model]["prompt"] += uint64(usage.PromptTokens)
+	m.tokens[model]["completion"] += uint64(usage.CompletionTokens)
+}
+
+func (m *metrics) upstreamError() {
+	m.mu.Lock()
+	m.upstreamErrors++
+	m.mu.Unlock()
+}
+
+func (m *metrics) write(w io.Writer, queueDepth, activeJobs int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounterVec(w, "codebase_maker_generations_started_total", "Generations started.", m.started)
+	writeCounterVec(w, "codebase_maker_generations_completed_total", "Generations completed successfully.", m.completed)
+	writeCounterVec(w, "codebase_maker_generations_failed_total", "Generations that ended with an error.", m.failed)
+
+	fmt.Fprintln(w, "# HELP codebase_maker_generation_duration_seconds Time spent generating a project.")
+	fmt.Fprintln(w, "# TYPE codebase_maker_generation_duration_seconds histogram")
+	for _, labels := range sortedLabels(m.latency) {
+		h := m.latency[labels]
+		base := fmt.Sprintf(`template="%s",model="%s"`, escapeLabel(labels.template), escapeLabel(labels.model))
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "codebase_maker_generation_duration_seconds_bucket{%s,le=\"%g\"} %d\n", base, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "codebase_maker_generation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", base, h.count)
+		fmt.Fprintf(w, "codebase_maker_generation_duration_seconds_sum{%s} %g\n", base, h.sum)
+		fmt.Fprintf(w, "codebase_maker_generation_duration_seconds_count{%s} %d\n", base, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP codebase_maker_tokens_total Tokens consumed from the model provider.")
+	fmt.Fprintln(w, "# TYPE codebase_maker_tokens_total counter")
+	models := make([]string, 0, len(m.tokens))
+	for model := range m.tokens {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		for _, kind := range []string{"prompt", "completion"} {
+			fmt.Fprintf(w, "codebase_maker_tokens_total{model=\"%s\",kind=\"%s\"} %d\n", escapeLabel(model), kind, m.tokens[model][kind])
+		}
+	}
+
+	writeSingle(w, "codebase_maker_files_written_total", "Files written to disk.", "counter", m.filesWritten)
+	writeSingle(w, "codebase_maker_upstream_errors_total", "Errors returned by the model provider.", "counter", m.upstreamErrors)
+	writeSingle(w, "codebase_maker_active_websocket_connections", "Open WebSocket connections.", "gauge", m.activeConnections)
+	writeSingle(w, "codebase_maker_active_generations", "Generations currently running.", "gauge", activeJobs)
+	writeSingle(w, "codebase_maker_queue_depth", "File tasks waiting to be written.", "gauge", queueDepth)
+}
+
+func writeCounterVec(w io.Writer, name, help string, values map[generationLabels]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, labels := range sortedLabels(values) {
+		fmt.Fprintf(w, "%s{template=\"%s\",model=\"%s\"} %d\n", name, escapeLabel(labels.template), escapeLabel(labels.model), values[labels])
+	}
+}
+
+func writeSingle[T uint64 | int64 | int](w io.Writer, name, help, kind string, value T) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func sortedLabels[V any](values map[generationLabels]V) []generationLabels {
+	keys := make([]generationLabels, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].template != keys[j].template {
+			return keys[i].template < keys[j].template
+		}
+		return keys[i].model < keys[j].model
+	})
+
+	return keys
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	queueDepth, activeJobs := s.jobStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w, queueDepth, activeJobs)
+}
+
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// HandleReady reports whether the server can take new generations: the
+// output directory must be writable and the server not shutting down.
+func (s *Server) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if s.Draining() {
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	probe, err := os.CreateTemp(s.outputBase, ".readyz-*")
+	if err != nil {
+		http.Error(w, "output directory not writable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ready")
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/config"
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+// Options configure a server process: the generation settings in Config
+// plus the HTTP listener.
+type Options struct {
+	Config
+	Port      string
+	StaticDir string
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is the time running generations get to finish on
+	// shutdown.
+	ShutdownTimeout time.Duration
+}
+
+// OptionsFromConfig returns the server options of a resolved
+// configuration.
+func OptionsFromConfig(cfg *config.Config) Options {
+	return Options{
+		Config: Config{
+			OpenAIKey:  cfg.APIKey,
+			BaseURL:    cfg.BaseURL,
+			Retries:    cfg.Retries,
+			OutputBase: cfg.OutputDir,
+			Limits: Limits{
+				RequestsPerMinute: cfg.Server.RateLimit,
+				DailyTokenBudget:  cfg.Server.DailyTokenBudget,
+				DailyCostBudget:   cfg.Server.DailyCostBudget,
+				MaxPromptLength:   cfg.Server.MaxPromptLength,
+				MaxWorkerCount:    cfg.Server.MaxWorkers,
+				TrustProxy:        cfg.Server.TrustProxy,
+			},
+			Webhooks: WebhookConfig{
+				URLs:             cfg.Server.Webhooks,
+				Secret:           cfg.Server.WebhookSecret,
+				AllowRequestURLs: cfg.Server.AllowRequestWebhooks,
+			},
+			PublicURL:      cfg.Server.PublicURL,
+			TemplatePaths:  cfg.TemplatesPath,
+			TemplateReload: cfg.Server.TemplatesReload,
+		},
+		Port:            cfg.Server.Port,
+		StaticDir:       cfg.Server.StaticDir,
+		ReadTimeout:     cfg.Server.ReadTimeout,
+		WriteTimeout:    cfg.Server.WriteTimeout,
+		IdleTimeout:     cfg.Server.IdleTimeout,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+	}
+}
+
+// Handler routes the API, the downloads, the monitoring endpoints and the
+// web UI in staticDir.
+func (s *Server) Handler(staticDir string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+
+	mux.HandleFunc("/api/generate", s.HandleGenerate)
+	mux.HandleFunc("/api/reconnect", s.HandleReconnect)
+	mux.HandleFunc("/api/templates", s.HandleTemplates)
+	mux.HandleFunc("/api/addons", s.HandleAddons)
+	mux.HandleFunc("/api/languages", s.HandleLanguages)
+	mux.HandleFunc("/api/models", s.HandleModels)
+	mux.HandleFunc("/download/", s.HandleDownload)
+	mux.HandleFunc("/api/sessions/", s.HandleFiles)
+	mux.HandleFunc("/healthz", s.HandleHealth)
+	mux.HandleFunc("/readyz", s.HandleReady)
+	mux.HandleFunc("/metrics", s.HandleMetrics)
+
+	return mux
+}
+
+// httpShutdownTimeout is the time the HTTP server gets to close its
+// connections once the generations are drained.
+const httpShutdownTimeout = 5 * time.Second
+
+// Run serves until ctx is done, then drains the running generations and
+// shuts the HTTP server down.
+func Run(ctx context.Context, opts Options) error {
+	srv := NewServerWithConfig(opts.Config)
+
+	httpServer := &http.Server{
+		Addr:         ":" + opts.Port,
+		Handler:      srv.Handler(opts.StaticDir),
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+
+	listenErr := make(chan error, 1)
+
+	go func() {
+		srv.logger.Info("Server starting", "url", "http://localhost:"+opts.Port)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			listenErr <- err
+		}
+	}()
+
+	select {
+	case err := <-listenErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	srv.logger.Info("Shutdown signal received")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.logger.Warn("Some generations did not finish in time", logging.ErrorKey, err)
+	}
+
+	// the drain may have used up shutdownCtx, the listeners get their own
+	// time to close the connections left
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer httpCancel()
+
+	if err := httpServer.Shutdown(httpCtx); err != nil {
+		srv.logger.Error("Error shutting down HTTP server", logging.ErrorKey, err)
+	}
+
+	srv.logger.Info("Server stopped")
+
+	return nil
+}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -15,13 +17,62 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/cache"
+	"github.com/lFer17/codebase-maker/internal/logging"
+	"github.com/lFer17/codebase-maker/internal/tracing"
 )
 
 type Server struct {
 	agent      *agents.Agent
 	upgrader   websocket.Upgrader
 	openAIkey  string
+	apiBaseURL string
+	retries    int
 	outputBase string
+	limiter    *limiter
+	webhooks   WebhookConfig
+	baseURL    string
+	metrics    *metrics
+	jobs       map[string]*job
+	jobsMutex  sync.Mutex
+	inFlight   sync.WaitGroup
+	// webhooksInFlight counts the webhook deliveries running in the
+	// background.
+	webhooksInFlight sync.WaitGroup
+	draining         bool
+	logger           *slog.Logger
+	tracer           *tracing.Tracer
+	cache            *cache.Cache
+	ctx              context.Context
+	cancel           context.CancelFunc
+}
+
+type Config struct {
+	OpenAIKey string
+	// BaseURL is the OpenAI compatible API, defaulting to OpenAI.
+	BaseURL string
+	// Retries of failed OpenAI requests.
+	Retries    int
+	OutputBase string
+	Limits     Limits
+	Webhooks   WebhookConfig
+	// PublicURL is the externally visible base URL used in download links
+	// sent to webhooks. It defaults to the Host of each request.
+	PublicURL string
+	// TemplatePaths are searched for templates after the default paths.
+	TemplatePaths []string
+	// TemplateReload is how often the template paths are checked for
+	// changes; zero disables hot reload.
+	TemplateReload time.Duration
+	// Logger defaults to slog.Default(). Each generation logs through it
+	// with its session ID.
+	Logger *slog.Logger
+	// Tracer defaults to tracing.Default(). Each generation is a trace
+	// starting at the request that asked for it.
+	Tracer *tracing.Tracer
+	// Cache, if set, answers generations identical to earlier ones without
+	// querying the model, unless the request sets noCache.
+	Cache *cache.Cache
 }
 
 type WebSocketClient struct {
@@ -42,31 +93,125 @@ func (c *WebSocketClient) WriteJSON(v interface{}) error {
 }
 
 type ProjectRequest struct {
-	Prompt      string `json:"prompt"`
-	Language    string `json:"language"`
-	Template    string `json:"template"`
-	BasePackage string `json:"basePackage"`
-	WorkerCount int    `json:"workerCount"`
-	Model       string `json:"model"`
-	ProjectName string `json:"projectName"`
+	Prompt      string                 `json:"prompt"`
+	Language    string                 `json:"language"`
+	Template    string                 `json:"template"`
+	BasePackage string                 `json:"basePackage"`
+	WorkerCount int                    `json:"workerCount"`
+	Model       string                 `json:"model"`
+	ProjectName string                 `json:"projectName"`
+	Addons      []string               `json:"addons,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Webhooks    []string               `json:"webhooks,omitempty"`
+	// NoCache makes the generation query the model even if the response
+	// cache holds an answer.
+	NoCache bool `json:"noCache,omitempty"`
+	// Sampling overrides the sampling parameters of the template, one by
+	// one.
+	Sampling *SamplingRequest `json:"sampling,omitempty"`
+}
+
+// SamplingRequest holds the sampling parameters of a ProjectRequest under
+// the camelCase names of the API. Template manifests use the snake_case ones
+// of agents.SamplingParams.
+type SamplingRequest struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxTokens       int      `json:"maxTokens,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	ReasoningEffort string   `json:"reasoningEffort,omitempty"`
+}
+
+// Params returns the parameters of r.
+func (r SamplingRequest) Params() agents.SamplingParams {
+	return agents.SamplingParams(r)
+}
+
+type ReconnectRequest struct {
+	SessionID string `json:"sessionId"`
+	LastSeq   int    `json:"lastSeq"`
 }
+
 type ProgressEvent struct {
-	Type       string `json:"type"`
-	Message    string `json:"message"`
-	File       string `json:"file,omitempty"`
-	Error      string `json:"error,omitempty"`
-	ZipURL     string `json:"zipUrl,omitempty"`
-	ProjectDir string `json:"projectDir,omitempty"`
+	Seq        int           `json:"seq,omitempty"`
+	Type       string        `json:"type"`
+	Message    string        `json:"message"`
+	File       string        `json:"file,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	ZipURL     string        `json:"zipUrl,omitempty"`
+	ProjectDir string        `json:"projectDir,omitempty"`
+	SessionID  string        `json:"sessionId,omitempty"`
+	ResetAt    string        `json:"resetAt,omitempty"`
+	Usage      *agents.Usage `json:"usage,omitempty"`
 }
 
 func NewServer(openAIKey, outputBase string) *Server {
-	if err := os.MkdirAll(outputBase, 0755); err != nil {
-		log.Printf("Failed to create output base directory:%v", err)
+	return NewServerWithConfig(Config{
+		OpenAIKey:  openAIKey,
+		OutputBase: outputBase,
+	})
+}
+
+func NewServerWithConfig(cfg Config) *Server {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if err := os.MkdirAll(cfg.OutputBase, 0755); err != nil {
+		logger.Error("Failed to create output base directory", "dir", cfg.OutputBase, logging.ErrorKey, err)
+	}
+
+	tracer := cfg.Tracer
+	if tracer == nil {
+		tracer = tracing.Default()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// catalog agent, only used to list templates and languages
+	catalog, err := agents.NewAgentWithConfig(ctx, agents.AgentConfig{
+		OutputDir: cfg.OutputBase,
+		Template:  "default",
+		Logger:    logger,
+	})
+	if err != nil {
+		logger.Error("Failed to load templates", logging.ErrorKey, err)
+	}
+
+	if catalog != nil && len(cfg.TemplatePaths) > 0 {
+		if err := catalog.AddTemplatePaths(cfg.TemplatePaths...); err != nil {
+			logger.Error("Failed to load templates", logging.ErrorKey, err)
+		}
+	}
+
+	if catalog != nil && cfg.TemplateReload > 0 {
+		go catalog.WatchTemplates(ctx, cfg.TemplateReload, func(err error) {
+			if err != nil {
+				logger.Error("Failed to reload templates", logging.ErrorKey, err)
+				return
+			}
+			logger.Info("Templates changed, reloaded templates", "count", len(catalog.ListTemplates()))
+		})
 	}
 
 	return &Server{
-		openAIkey:  openAIKey,
-		outputBase: outputBase,
+		agent:      catalog,
+		ctx:        ctx,
+		cancel:     cancel,
+		openAIkey:  cfg.OpenAIKey,
+		apiBaseURL: cfg.BaseURL,
+		retries:    cfg.Retries,
+		logger:     logger,
+		tracer:     tracer,
+		cache:      cfg.Cache,
+		outputBase: cfg.OutputBase,
+		limiter:    newLimiter(cfg.Limits),
+		webhooks:   cfg.Webhooks,
+		baseURL:    cfg.PublicURL,
+		metrics:    newMetrics(),
+		jobs:       make(map[string]*job),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
@@ -85,19 +230,74 @@ func (s *Server) HandleGenerate(w http.ResponseWriter, r *http.Request) {
 
 	defer conn.Close()
 
+	s.metrics.connectionOpened()
+	defer s.metrics.connectionClosed()
+
 	wsClient := NewWebSocketClient(conn)
 
+	ctx, span := s.tracer.Start(r.Context(), "generate.request")
+	defer span.End()
+
 	var req ProjectRequest
 
 	err = conn.ReadJSON(&req)
 
 	if err != nil {
+		span.RecordError(err)
+		sendEvent(wsClient, ProgressEvent{
+			Type:  "error",
+			Error: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	span.SetAttributes(logging.TemplateKey, req.Template, logging.ModelKey, req.Model)
+
+	if err := s.limiter.checkRequest(&req); err != nil {
+		span.RecordError(err)
+		sendLimitError(wsClient, err)
+		return
+	}
+
+	if err := s.checkWebhooks(ctx, req); err != nil {
+		span.RecordError(err)
 		sendEvent(wsClient, ProgressEvent{
 			Type:  "error",
 			Error: "Invalid request: " + err.Error(),
 		})
 		return
 	}
+
+	if req.Sampling != nil {
+		if err := req.Sampling.Params().Validate(); err != nil {
+			span.RecordError(err)
+			sendEvent(wsClient, ProgressEvent{
+				Type:  "error",
+				Error: "Invalid request: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := s.beginJob(); err != nil {
+		span.RecordError(err)
+		sendEvent(wsClient, ProgressEvent{
+			Type:  "error",
+			Error: "Request rejected: " + err.Error(),
+		})
+		return
+	}
+
+	clientKey := s.limiter.clientKey(r)
+
+	res, err := s.limiter.allow(clientKey, req.Model)
+	if err != nil {
+		span.RecordError(err)
+		s.endJob()
+		sendLimitError(wsClient, err)
+		return
+	}
+
 	projectName := req.ProjectName
 
 	if projectName == "" {
@@ -109,6 +309,9 @@ func (s *Server) HandleGenerate(w http.ResponseWriter, r *http.Request) {
 	sessionDir := filepath.Join(s.outputBase, sessionID)
 
 	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		span.RecordError(err)
+		s.limiter.settle(res, agents.Usage{})
+		s.endJob()
 		sendEvent(wsClient, ProgressEvent{
 			Type:  "error",
 			Error: "Failed to create a session directory: " + err.Error(),
@@ -119,14 +322,119 @@ func (s *Server) HandleGenerate(w http.ResponseWriter, r *http.Request) {
 	projectDir := filepath.Join(sessionDir, projectName)
 
 	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		span.RecordError(err)
+		s.limiter.settle(res, agents.Usage{})
+		s.endJob()
 		sendEvent(wsClient, ProgressEvent{
-			Type:  "Error",
+			Type:  "error",
 			Error: "Failed to create project directory: " + err.Error(),
 		})
 		return
 	}
 
-	ctx := context.Background()
+	j := newJob(sessionID, sessionDir, projectName, req, s.logger)
+	j.clientKey = clientKey
+	j.reservation = res
+	j.logger.Info("Generation requested",
+		"project", projectName,
+		logging.TemplateKey, req.Template,
+		logging.ModelKey, req.Model,
+		logging.LanguageKey, req.Language,
+		"workers", req.WorkerCount)
+	j.baseURL = s.publicURL(r)
+
+	// the generation outlives the request, so its span is carried by the
+	// server context instead of the request one
+	_, j.span = tracing.Start(ctx, "generation",
+		logging.SessionKey, sessionID,
+		logging.TemplateKey, req.Template,
+		logging.ModelKey, req.Model,
+		logging.LanguageKey, req.Language)
+	j.ctx = tracing.ContextWithSpan(s.ctx, j.span)
+	span.SetAttributes(logging.SessionKey, sessionID)
+	span.End()
+
+	s.addJob(j)
+
+	// the generation outlives this connection, clients that drop can pick
+	// it up again through HandleReconnect
+	go s.runJob(j)
+
+	streamJob(wsClient, j, 0)
+}
+
+// HandleReconnect resumes the event stream of a session. The client sends
+// the session ID and the last sequence number it saw, receives the events
+// it missed and then keeps streaming until the generation ends.
+func (s *Server) HandleReconnect(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		http.Error(w, "Could not upgrade connection", http.StatusInternalServerError)
+		return
+	}
+
+	defer conn.Close()
+
+	s.metrics.connectionOpened()
+	defer s.metrics.connectionClosed()
+
+	wsClient := NewWebSocketClient(conn)
+
+	var req ReconnectRequest
+
+	if err := conn.ReadJSON(&req); err != nil {
+		sendEvent(wsClient, ProgressEvent{
+			Type:  "error",
+			Error: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	if j, ok := s.getJob(req.SessionID); ok {
+		streamJob(wsClient, j, req.LastSeq)
+		return
+	}
+
+	if _, err := uuid.Parse(req.SessionID); err != nil {
+		sendEvent(wsClient, ProgressEvent{
+			Type:  "error",
+			Error: ErrSessionNotFound.Error(),
+		})
+		return
+	}
+
+	events, err := readEventLog(filepath.Join(s.outputBase, req.SessionID), req.LastSeq)
+	if err != nil && len(events) == 0 {
+		sendEvent(wsClient, ProgressEvent{
+			Type:  "error",
+			Error: ErrSessionNotFound.Error(),
+		})
+		return
+	}
+
+	for _, event := range events {
+		sendEvent(wsClient, event)
+	}
+}
+
+// runJob generates the project of j, publishing its progress as events.
+func (s *Server) runJob(j *job) {
+	var usage agents.Usage
+
+	defer s.endJob()
+	defer func() { s.limiter.settle(j.reservation, usage) }()
+	defer s.removeJob(j.id)
+	defer j.finish()
+	defer j.span.End()
+
+	req := j.request
+
+	writeSessionStatus(j.sessionDir, statusRunning, "")
+
+	ctx := j.ctx
+
+	_, initSpan := tracing.Start(ctx, "agent.init")
 	// Consider use streaming function from OpenAi
 	httpClient := http.Client{
 		Timeout: 1000 * time.Second,
@@ -144,112 +452,253 @@ func (s *Server) HandleGenerate(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	client := agents.NewOpenAI(ctx, s.openAIkey, req.Model, &httpClient)
+	responses := s.cache
+	if req.NoCache {
+		responses = nil
+	}
+
+	var sampling agents.SamplingParams
+	if req.Sampling != nil {
+		sampling = req.Sampling.Params()
+	}
+
+	client := agents.NewOpenAIWithConfig(ctx, agents.OpenAIConfig{
+		APIKey:     s.openAIkey,
+		Model:      req.Model,
+		BaseURL:    s.apiBaseURL,
+		Retries:    s.retries,
+		HTTPClient: &httpClient,
+		Logger:     j.logger,
+		Cache:      responses,
+		Sampling:   sampling,
+	})
 
 	progressCallBack := func(eventType, message, file string) {
-		sendEvent(wsClient, ProgressEvent{
+		j.publish(ProgressEvent{
 			Type:       eventType,
 			Message:    message,
 			File:       file,
-			ProjectDir: projectName,
+			ProjectDir: j.projectName,
 		})
 	}
 
-	agent, err := agents.NewAgentWithCallback(
-		ctx, client, projectDir, req.BasePackage,
-		req.Template, req.Language, req.WorkerCount,
-		progressCallBack,
-	)
+	agent, err := agents.NewAgentWithConfig(ctx, agents.AgentConfig{
+		OpenAI:      client,
+		OutputDir:   j.projectDir,
+		BasePackage: req.BasePackage,
+		Template:    req.Template,
+		Language:    req.Language,
+		WorkerCount: req.WorkerCount,
+		CallBack:    progressCallBack,
+		Logger:      j.logger,
+	})
 
 	if err != nil {
-		sendEvent(wsClient, ProgressEvent{
+		initSpan.RecordError(err)
+		initSpan.End()
+		j.span.RecordError(err)
+		j.logger.Error("Failed to initialize agent", logging.ErrorKey, err)
+		j.publish(ProgressEvent{
 			Type:  "error",
 			Error: "Failed to initialize agent: " + err.Error(),
 		})
+		writeSessionStatus(j.sessionDir, statusFailed, err.Error())
+		s.notifyWebhooks(j, agents.Usage{}, err)
 		return
 	}
 
+	// generate with the templates the catalog shows, hot reloads included
+	if s.agent != nil {
+		agent.ShareCatalog(s.agent)
+	}
+
+	if err := agent.UseAddons(req.Addons...); err != nil {
+		initSpan.RecordError(err)
+		initSpan.End()
+		j.span.RecordError(err)
+		j.logger.Warn("Invalid add-ons", "addons", req.Addons, logging.ErrorKey, err)
+		j.publish(ProgressEvent{
+			Type:  "error",
+			Error: "Invalid add-ons: " + err.Error(),
+		})
+		writeSessionStatus(j.sessionDir, statusFailed, err.Error())
+		s.notifyWebhooks(j, agents.Usage{}, err)
+		return
+	}
+
+	params := make(map[string]string, len(req.Params))
+	for name, value := range req.Params {
+		params[name] = fmt.Sprint(value)
+	}
+	agent.SetParams(params)
+	agent.SetProjectName(j.projectName)
+
+	initSpan.End()
+
+	j.setAgent(agent)
+
+	s.metrics.generationStarted(req.Template, req.Model)
+	started := time.Now()
+
 	agent.Start()
 
-	sendEvent(wsClient, ProgressEvent{
+	j.publish(ProgressEvent{
 		Type:    "start",
 		Message: "Starting code generation",
 	})
 
-	if err := agent.GenerateCode(req.Prompt); err != nil {
-		sendEvent(wsClient, ProgressEvent{
+	err = agent.GenerateCodeContext(ctx, req.Prompt)
+	j.span.RecordError(err)
+
+	usage = agent.Usage()
+
+	if errors.Is(err, agents.ErrUpstream) && ctx.Err() == nil {
+		s.metrics.upstreamError()
+	}
+
+	if err != nil {
+		j.logger.Error("Code generation failed",
+			logging.TemplateKey, req.Template,
+			logging.ModelKey, req.Model,
+			"duration", time.Since(started),
+			"total_tokens", usage.TotalTokens,
+			logging.ErrorKey, err)
+		if ctx.Err() != nil {
+			writeSessionStatus(j.sessionDir, statusPartial, ErrShuttingDown.Error())
+		} else {
+			writeSessionStatus(j.sessionDir, statusFailed, err.Error())
+		}
+		s.metrics.generationFinished(req.Template, req.Model, time.Since(started), len(agent.WrittenFiles()), usage, err)
+		j.publish(ProgressEvent{
 			Type:  "error",
 			Error: "Code generation failed: " + err.Error(),
 		})
 		agent.Stop()
+		s.notifyWebhooks(j, usage, err)
 		return
 	}
 
+	j.publish(ProgressEvent{
+		Type:    "usage",
+		Message: fmt.Sprintf("Used %d tokens (~$%.4f)", usage.TotalTokens, agents.EstimateCost(req.Model, usage)),
+		Usage:   &usage,
+	})
+
 	time.Sleep(1 * time.Second)
 	agent.Stop()
 
-	zipName := fmt.Sprintf("%s.zip", projectName)
-	zipPath := filepath.Join(sessionDir, zipName)
+	s.metrics.generationFinished(req.Template, req.Model, time.Since(started), len(agent.WrittenFiles()), usage, nil)
 
-	sendEvent(wsClient, ProgressEvent{
-		Type:  "file",
-		Error: "Generating Zip file: " + zipName,
-	})
+	writeSessionStatus(j.sessionDir, statusComplete, "")
 
-	if err := createZip(projectDir, zipPath); err != nil {
-		sendEvent(wsClient, ProgressEvent{
-			Type:  "error",
-			Error: "Failed to create zip file: " + err.Error(),
-		})
-	}
+	j.logger.Info("Code generation completed",
+		logging.TemplateKey, req.Template,
+		logging.ModelKey, req.Model,
+		"duration", time.Since(started),
+		"files", len(agent.WrittenFiles()),
+		"total_tokens", usage.TotalTokens)
 
-	zipURL := "/download/" + sessionID
+	zipURL := "/download/" + j.id
 
-	sendEvent(wsClient, ProgressEvent{
+	j.publish(ProgressEvent{
 		Type:    "complete",
 		Message: "Code generation completed!",
 		ZipURL:  zipURL,
 	})
+
+	s.notifyWebhooks(j, usage, nil)
 }
 
+// HandleDownload streams a session's project as an archive. The format
+// query parameter selects zip (default) or tar.gz and paths, either repeated
+// or comma separated, restricts the archive to some files or directories.
 func (s *Server) HandleDownload(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Path[len("/download/"):]
-	sessionDir := filepath.Join(s.outputBase, sessionID)
 
-	files, err := os.ReadDir(sessionDir)
+	_, span := s.tracer.Start(r.Context(), "archive", logging.SessionKey, sessionID)
+	defer span.End()
 
+	sessionDir, projectName, err := s.sessionProject(sessionID)
 	if err != nil {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
-	var zipName string
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatZip
+	}
 
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".zip") {
-			zipName = file.Name()
-			break
+	if format != formatZip && format != formatTarGz {
+		http.Error(w, "Unsupported format, use zip or tar.gz", http.StatusBadRequest)
+		return
+	}
+
+	var paths []string
+	for _, value := range r.URL.Query()["paths"] {
+		for _, p := range strings.Split(value, ",") {
+			p = strings.Trim(strings.TrimSpace(p), "/")
+			if p == "" {
+				continue
+			}
+			if !fs.ValidPath(p) {
+				http.Error(w, "Invalid path: "+p, http.StatusBadRequest)
+				return
+			}
+			paths = append(paths, p)
 		}
 	}
 
-	if zipName == "" {
-		http.Error(w, "Zip file not found", http.StatusNotFound)
+	files, err := collectArchiveFiles(filepath.Join(sessionDir, projectName), paths)
+	if err != nil {
+		http.Error(w, "Could not read project: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(files) == 0 {
+		http.Error(w, "No files match the requested paths", http.StatusNotFound)
 		return
 	}
 
-	zipPath := filepath.Join(sessionDir, zipName)
+	span.SetAttributes("format", format, "files", len(files))
+
+	archiveName := projectName + "." + format
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName))
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", zipName))
-	w.Header().Set("Content-Type", "application/zip")
-	http.ServeFile(w, r, zipPath)
+	if format == formatTarGz {
+		w.Header().Set("Content-Type", "application/gzip")
+		err = writeTarGz(w, projectName, files)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(w, projectName, files)
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		s.logger.Error("Error streaming archive", logging.SessionKey, sessionID, logging.ErrorKey, err)
+	}
+}
+
+func sendLimitError(client *WebSocketClient, err error) {
+	event := ProgressEvent{
+		Type:  "error",
+		Error: "Request rejected: " + err.Error(),
+	}
+
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) && !limitErr.ResetAt.IsZero() {
+		event.ResetAt = limitErr.ResetAt.Format(time.RFC3339)
+	}
 
+	sendEvent(client, event)
 }
 
 func sendEvent(client *WebSocketClient, event ProgressEvent) {
 	err := client.WriteJSON(event)
 
 	if err != nil {
-		log.Printf("Error writing data to connection: %v \n", err)
+		slog.Debug("Error writing data to connection", logging.ErrorKey, err)
 	}
 
 }
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+const (
+	sessionStatusFile = "session.json"
+
+	statusRunning  = "running"
+	statusComplete = "complete"
+	statusFailed   = "failed"
+	statusPartial  = "partial"
+)
+
+type sessionStatus struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func writeSessionStatus(sessionDir, status, errMsg string) {
+	bs, err := json.MarshalIndent(sessionStatus{
+		Status:    status,
+		Error:     errMsg,
+		UpdatedAt: time.Now().UTC(),
+	}, "", "  ")
+
+	if err != nil {
+		slog.Error("Error encoding session status", logging.ErrorKey, err)
+		return
+	}
+
+	if err := os.WriteFile(filepath.Join(sessionDir, sessionStatusFile), bs, 0644); err != nil {
+		slog.Error("Error writing session status", "dir", sessionDir, logging.ErrorKey, err)
+	}
+}
+
+// beginJob reserves a slot for a new generation, failing once the server
+// started shutting down.
+func (s *Server) beginJob() error {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	if s.draining {
+		return ErrShuttingDown
+	}
+
+	s.inFlight.Add(1)
+
+	return nil
+}
+
+func (s *Server) endJob() {
+	s.inFlight.Done()
+}
+
+// Draining reports whether Shutdown has been called.
+func (s *Server) Draining() bool {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	return s.draining
+}
+
+// Shutdown stops accepting generations, tells connected clients and waits
+// for running generations, and the webhooks they send, to finish. When ctx
+// expires first the remaining generations and deliveries are cancelled and
+// the sessions are marked as partial.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.jobsMutex.Lock()
+	s.draining = true
+	running := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		running = append(running, j)
+	}
+	s.jobsMutex.Unlock()
+
+	s.logger.Info("Shutting down, waiting for running generations", "running", len(running))
+
+	for _, j := range running {
+		j.publish(ProgressEvent{
+			Type:    "shutdown",
+			Message: "Server is shutting down, running generations get a grace period to finish",
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		s.webhooksInFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.logger.Warn("Shutdown deadline reached, cancelling running generations")
+	s.cancel()
+
+	// give cancelled handlers a moment to record their partial sessions
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+
+	return ctx.Err()
+}
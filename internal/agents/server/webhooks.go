@@ -0,0 +1,390 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+const (
+	webhookEventCompleted = "generation.completed"
+	webhookEventFailed    = "generation.failed"
+
+	signatureHeader = "X-Codebase-Maker-Signature"
+	eventHeader     = "X-Codebase-Maker-Event"
+	deliveryHeader  = "X-Codebase-Maker-Delivery"
+
+	defaultWebhookAttempts = 4
+)
+
+// WebhookConfig configures the notifications sent when a generation ends.
+// URLs receive every job; requests can add their own URLs when
+// AllowRequestURLs is set, as long as they resolve to public addresses.
+// Payloads are signed with Secret when present.
+type WebhookConfig struct {
+	URLs             []string
+	Secret           string
+	AllowRequestURLs bool
+	MaxAttempts      int
+	Timeout          time.Duration
+	// Backoff is the wait before the first retry, doubled on each retry. It
+	// defaults to a second.
+	Backoff time.Duration
+}
+
+func (c WebhookConfig) backoff() time.Duration {
+	if c.Backoff <= 0 {
+		return time.Second
+	}
+
+	return c.Backoff
+}
+
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type WebhookPayload struct {
+	Event       string          `json:"event"`
+	SessionID   string          `json:"sessionId"`
+	ProjectName string          `json:"projectName"`
+	Template    string          `json:"template"`
+	Language    string          `json:"language"`
+	Model       string          `json:"model"`
+	Files       []string        `json:"files"`
+	Manifest    []ManifestEntry `json:"manifest"`
+	Usage       agents.Usage    `json:"usage"`
+	DownloadURL string          `json:"downloadUrl,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	FinishedAt  time.Time       `json:"finishedAt"`
+}
+
+// SignPayload returns the value of the signature header for body: the hex
+// encoded HMAC-SHA256 of the body keyed with secret, prefixed by "sha256=".
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", raw, err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: must be an absolute http or https URL", raw)
+	}
+
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip may receive the webhooks of clients.
+// Loopback, private, link-local (cloud metadata endpoints included),
+// unspecified and multicast addresses are refused, so requests cannot make
+// the server reach its own network.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// checkPublicHost resolves the host of a webhook URL and fails unless every
+// address is public.
+func checkPublicHost(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", raw, err)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", raw, err)
+	}
+
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("invalid webhook URL %q: %s is not a public address", raw, addr.IP)
+		}
+	}
+
+	return nil
+}
+
+// publicOnly is a dialer control refusing connections to non public
+// addresses. It checks the address actually dialed, so neither redirects
+// nor DNS answers changing after checkPublicHost get around it.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+
+	return nil
+}
+
+// checkWebhooks validates the webhook URLs sent with a request.
+func (s *Server) checkWebhooks(ctx context.Context, req ProjectRequest) error {
+	if len(req.Webhooks) == 0 {
+		return nil
+	}
+
+	if !s.webhooks.AllowRequestURLs {
+		return fmt.Errorf("this server does not accept webhooks in requests")
+	}
+
+	for _, raw := range req.Webhooks {
+		if err := validateWebhookURL(raw); err != nil {
+			return err
+		}
+		if err := checkPublicHost(ctx, raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publicURL returns the base URL clients used to reach the server.
+func (s *Server) publicURL(r *http.Request) string {
+	if s.baseURL != "" {
+		return strings.TrimSuffix(s.baseURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && s.limiter.limits.TrustProxy {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func buildManifest(projectDir string) ([]ManifestEntry, error) {
+	manifest := []ManifestEntry{}
+
+	err := filepath.WalkDir(projectDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(projectDir, p)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+
+		manifest = append(manifest, ManifestEntry{
+			Path:   filepath.ToSlash(rel),
+			Size:   size,
+			SHA256: hex.EncodeToString(hash.Sum(nil)),
+		})
+
+		return nil
+	})
+
+	return manifest, err
+}
+
+// notifyWebhooks posts the outcome of j to the server and request webhooks.
+// Delivery runs in the background, so slow receivers hold neither the job
+// nor its client; Shutdown waits for it within its deadline and cancels the
+// deliveries left.
+func (s *Server) notifyWebhooks(j *job, usage agents.Usage, genErr error) {
+	if len(s.webhooks.URLs) == 0 && len(j.request.Webhooks) == 0 {
+		return
+	}
+
+	s.webhooksInFlight.Add(1)
+	go func() {
+		defer s.webhooksInFlight.Done()
+		s.sendWebhooks(s.ctx, j, usage, genErr)
+	}()
+}
+
+func (s *Server) sendWebhooks(ctx context.Context, j *job, usage agents.Usage, genErr error) {
+	payload := WebhookPayload{
+		Event:       webhookEventCompleted,
+		SessionID:   j.id,
+		ProjectName: j.projectName,
+		Template:    j.request.Template,
+		Language:    j.request.Language,
+		Model:       j.request.Model,
+		Files:       []string{},
+		Usage:       usage,
+		FinishedAt:  time.Now().UTC(),
+	}
+
+	if genErr != nil {
+		payload.Event = webhookEventFailed
+		payload.Error = genErr.Error()
+	} else {
+		payload.DownloadURL = j.baseURL + "/download/" + j.id
+	}
+
+	manifest, err := buildManifest(j.projectDir)
+	if err != nil {
+		j.logger.Error("Error building manifest", logging.ErrorKey, err)
+	}
+	payload.Manifest = manifest
+	for _, entry := range manifest {
+		payload.Files = append(payload.Files, entry.Path)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		j.logger.Error("Error encoding webhook payload", logging.ErrorKey, err)
+		return
+	}
+
+	// the operator configured the server URLs, clients the request ones
+	for _, target := range s.webhooks.URLs {
+		if err := s.deliverWebhook(ctx, target, payload.Event, body, false); err != nil {
+			j.logger.Warn("Webhook failed", "url", target, logging.ErrorKey, err)
+		}
+	}
+	for _, target := range j.request.Webhooks {
+		if err := s.deliverWebhook(ctx, target, payload.Event, body, true); err != nil {
+			j.logger.Warn("Webhook failed", "url", target, logging.ErrorKey, err)
+		}
+	}
+}
+
+// webhookClient returns the client delivering webhooks. Redirects are not
+// followed, and a client sending to public addresses only refuses to
+// connect anywhere else.
+func webhookClient(timeout time.Duration, publicOnlyAddrs bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if publicOnlyAddrs {
+		transport.Proxy = nil
+		transport.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   publicOnly,
+		}).DialContext
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deliverWebhook posts body to target, retrying with exponential backoff on
+// network errors, 429 and 5xx responses, until ctx is done.
+func (s *Server) deliverWebhook(ctx context.Context, target, event string, body []byte, publicOnlyAddrs bool) error {
+	attempts := s.webhooks.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultWebhookAttempts
+	}
+
+	timeout := s.webhooks.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	client := webhookClient(timeout, publicOnlyAddrs)
+	defer client.CloseIdleConnections()
+
+	deliveryID := uuid.New().String()
+	backoff := s.webhooks.backoff()
+
+	var lastErr error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("cancelled after %d attempts: %w", attempt-1, lastErr)
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "codebase-maker-webhook")
+		req.Header.Set(eventHeader, event)
+		req.Header.Set(deliveryHeader, deliveryID)
+		if s.webhooks.Secret != "" {
+			req.Header.Set(signatureHeader, SignPayload(s.webhooks.Secret, body))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				return err
+			}
+			continue
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+
+		lastErr = fmt.Errorf("unexpected status %s", resp.Status)
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, lastErr)
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lFer17/codebase-maker/internal/agents"
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+func newWebhookServer(cfg WebhookConfig) *Server {
+	if cfg.Backoff == 0 {
+		cfg.Backoff = time.Millisecond
+	}
+
+	return &Server{webhooks: cfg, ctx: context.Background(), logger: logging.Discard()}
+}
+
+func TestDeliverWebhookSignsPayload(t *testing.T) {
+	body := []byte(`{"event":"generation.completed"}`)
+
+	var got atomic.Value
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		if string(received) != string(body) {
+			t.Errorf("body = %s, want %s", received, body)
+		}
+		if r.Header.Get(eventHeader) != webhookEventCompleted {
+			t.Errorf("event header = %q", r.Header.Get(eventHeader))
+		}
+		got.Store(r.Header.Get(signatureHeader))
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{Secret: "s3cret"})
+
+	if err := s.deliverWebhook(context.Background(), receiver.URL, webhookEventCompleted, body, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := SignPayload("s3cret", body); got.Load() != want {
+		t.Errorf("signature = %v, want %s", got.Load(), want)
+	}
+	if !strings.HasPrefix(SignPayload("s3cret", body), "sha256=") {
+		t.Errorf("signature without sha256= prefix")
+	}
+}
+
+func TestDeliverWebhookRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{MaxAttempts: 4})
+
+	if err := s.deliverWebhook(context.Background(), receiver.URL, webhookEventCompleted, []byte(`{}`), false); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestDeliverWebhookGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{MaxAttempts: 3})
+
+	err := s.deliverWebhook(context.Background(), receiver.URL, webhookEventCompleted, []byte(`{}`), false)
+	if err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Fatalf("err = %v, want giving up", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestDeliverWebhookDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{MaxAttempts: 4})
+
+	if err := s.deliverWebhook(context.Background(), receiver.URL, webhookEventCompleted, []byte(`{}`), false); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestDeliverWebhookStopsWhenCancelled(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{MaxAttempts: 4, Backoff: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.deliverWebhook(ctx, receiver.URL, webhookEventCompleted, []byte(`{}`), false)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery not cancelled")
+	}
+}
+
+func TestDeliverWebhookDoesNotFollowRedirects(t *testing.T) {
+	var followed atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed.Store(true)
+	}))
+	defer target.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{})
+
+	if err := s.deliverWebhook(context.Background(), receiver.URL, webhookEventCompleted, []byte(`{}`), false); err == nil {
+		t.Fatal("expected an error for a redirect")
+	}
+	if followed.Load() {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestDeliverWebhookPublicOnlyRefusesLoopback(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	s := newWebhookServer(WebhookConfig{MaxAttempts: 1})
+
+	if err := s.deliverWebhook(context.Background(), receiver.URL, webhookEventCompleted, []byte(`{}`), true); err == nil {
+		t.Fatal("expected loopback to be refused")
+	}
+	if calls.Load() != 0 {
+		t.Errorf("calls = %d, want 0", calls.Load())
+	}
+}
+
+func TestCheckWebhooks(t *testing.T) {
+	s := newWebhookServer(WebhookConfig{AllowRequestURLs: true})
+
+	for _, raw := range []string{
+		"ftp://example.com/hook",
+		"/relative",
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://100.64.0.1/hook",
+	} {
+		if err := s.checkWebhooks(context.Background(), ProjectRequest{Webhooks: []string{raw}}); err == nil {
+			t.Errorf("%s accepted", raw)
+		}
+	}
+
+	if err := s.checkWebhooks(context.Background(), ProjectRequest{Webhooks: []string{"http://93.184.216.34/hook"}}); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+
+	s.webhooks.AllowRequestURLs = false
+	if err := s.checkWebhooks(context.Background(), ProjectRequest{Webhooks: []string{"http://93.184.216.34/hook"}}); err == nil {
+		t.Error("request webhooks accepted while disabled")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.0.1":      false,
+		"169.254.169.254":  false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := isPublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestNotifyWebhooksSendsManifest(t *testing.T) {
+	payloads := make(chan WebhookPayload, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		payloads <- payload
+	}))
+	defer receiver.Close()
+
+	sessionDir := t.TempDir()
+	projectDir := filepath.Join(sessionDir, "demo")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newWebhookServer(WebhookConfig{URLs: []string{receiver.URL}})
+	j := newJob("session-1", sessionDir, "demo", ProjectRequest{Template: "go-cli"}, logging.Discard())
+	j.baseURL = "http://maker.example"
+
+	s.notifyWebhooks(j, agents.Usage{TotalTokens: 10}, nil)
+	s.webhooksInFlight.Wait()
+
+	select {
+	case payload := <-payloads:
+		if payload.Event != webhookEventCompleted || payload.SessionID != "session-1" {
+			t.Errorf("payload = %+v", payload)
+		}
+		if payload.DownloadURL != "http://maker.example/download/session-1" {
+			t.Errorf("download URL = %s", payload.DownloadURL)
+		}
+		if len(payload.Manifest) != 1 || payload.Manifest[0].Path != "main.go" || payload.Manifest[0].Size != 13 {
+			t.Errorf("manifest = %+v", payload.Manifest)
+		}
+	default:
+		t.Fatal("no webhook received")
+	}
+}
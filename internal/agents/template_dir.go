@@ -0,0 +1,442 @@
+package agents
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+// Template packages are directories holding a manifest, template.yaml or
+// template.json, with the ProjectTemplate fields and a files/ tree that is
+// copied into the project. Text files and their paths are rendered with
+// text/template, binary files are copied as they are. A trailing .tmpl is
+// dropped from file names, so packages can ship files such as go.mod that
+// would otherwise be picked up by the Go tooling.
+var manifestNames = []string{"template.yaml", "template.yml", "template.json"}
+
+const (
+	templateFilesDir = "files"
+	templateSuffix   = ".tmpl"
+)
+
+// templateTree is the files/ directory of a template package.
+type templateTree struct {
+	fsys   fs.FS
+	source string
+}
+
+// templateFile is a file rendered from a template package.
+type templateFile struct {
+	Path    string
+	Content string
+	Mode    fs.FileMode
+	// tree is the index of the package tree the file comes from.
+	tree int
+}
+
+// parseTemplateManifest decodes a template from JSON or YAML depending on
+// the file name. Strict decoding rejects unknown fields.
+func parseTemplateManifest(name string, data []byte, strict bool) (ProjectTemplate, error) {
+	var tmpl ProjectTemplate
+
+	if strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(strict)
+		if err := dec.Decode(&tmpl); err != nil && err != io.EOF {
+			return tmpl, err
+		}
+		return tmpl, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&tmpl); err != nil {
+		return tmpl, jsonErrorPosition(data, err)
+	}
+
+	return tmpl, nil
+}
+
+// jsonErrorPosition adds the line and column to JSON syntax and type errors.
+func jsonErrorPosition(data []byte, err error) error {
+	var offset int64
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+
+	before := data[:min(int(offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// templateEntry is a template read from a template directory, or the error
+// that prevented reading it.
+type templateEntry struct {
+	Template ProjectTemplate
+	// Source is the file or package directory the template comes from.
+	Source string
+	Err    error
+}
+
+// readTemplateDir reads the templates in root of fsys: JSON files and
+// template package directories. Subdirectories used for add-ons and prompts
+// and dot files are skipped.
+func readTemplateDir(fsys fs.FS, root, source string, strict bool) ([]templateEntry, error) {
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []templateEntry
+
+	for _, entry := range entries {
+		name := entry.Name()
+		loaded := templateEntry{Source: source + "/" + name}
+
+		switch {
+		case strings.HasPrefix(name, "."):
+			continue
+		case entry.IsDir() && (name == "addons" || name == "prompts"):
+			continue
+		case entry.IsDir():
+			loaded.Template, loaded.Err = loadTemplatePackage(fsys, path.Join(root, name), loaded.Source, strict)
+			if loaded.Err == errNotTemplatePackage {
+				continue
+			}
+		case strings.HasSuffix(name, ".json"):
+			data, err := fs.ReadFile(fsys, path.Join(root, name))
+			if err != nil {
+				loaded.Err = err
+				break
+			}
+			loaded.Template, loaded.Err = parseTemplateManifest(name, data, strict)
+			if loaded.Err == nil {
+				loaded.Err = loadReferences(fsys, root, &loaded.Template)
+			}
+		default:
+			continue
+		}
+
+		result = append(result, loaded)
+	}
+
+	return result, nil
+}
+
+// promptEntry is a prompt template read from a prompts directory.
+type promptEntry struct {
+	Prompt PromptTemplate
+	Source string
+	Err    error
+}
+
+// readPromptDir reads the JSON prompt templates in root of fsys.
+func readPromptDir(fsys fs.FS, root, source string, strict bool) ([]promptEntry, error) {
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []promptEntry
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		loaded := promptEntry{Source: source + "/" + entry.Name()}
+
+		data, err := fs.ReadFile(fsys, path.Join(root, entry.Name()))
+		if err != nil {
+			loaded.Err = err
+			result = append(result, loaded)
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(&loaded.Prompt); err != nil {
+			loaded.Err = jsonErrorPosition(data, err)
+		}
+
+		result = append(result, loaded)
+	}
+
+	return result, nil
+}
+
+// loadTemplatesFrom adds the templates in root of fsys to the agent. It
+// returns the number of templates loaded.
+func (a *Agent) loadTemplatesFrom(fsys fs.FS, root, source string) int {
+	entries, err := readTemplateDir(fsys, root, source, false)
+	if err != nil {
+		a.logger.Warn("Error reading template directory", logging.SourceKey, source, logging.ErrorKey, err)
+		return 0
+	}
+
+	loaded := 0
+
+	for _, entry := range entries {
+		if entry.Err != nil {
+			a.logger.Warn("Invalid template", logging.SourceKey, entry.Source, logging.ErrorKey, entry.Err)
+			continue
+		}
+
+		tmpl := entry.Template
+
+		if err := tmpl.checkFilePaths(); err != nil {
+			a.logger.Warn("Invalid template", logging.SourceKey, entry.Source, logging.ErrorKey, err)
+			continue
+		}
+
+		if _, exists := a.templates[tmpl.Name]; exists {
+			a.logger.Info("Template overrides template with same name", logging.TemplateKey, tmpl.Name, logging.SourceKey, source)
+		}
+
+		a.templates[tmpl.Name] = tmpl
+		a.logger.Debug("Loaded template", logging.TemplateKey, tmpl.Name, logging.LanguageKey, tmpl.Language, logging.SourceKey, source)
+		loaded++
+	}
+
+	return loaded
+}
+
+// checkFilePaths fails if an inline file of tmpl would be written outside
+// the project. Templates fetched from remote sources are not trusted, so
+// this is checked when loading and not only by the validator.
+func (tmpl ProjectTemplate) checkFilePaths() error {
+	for _, p := range sortedKeys(tmpl.Files) {
+		if msg := checkFilePath(p); msg != "" {
+			return fmt.Errorf("file %q: %s", p, msg)
+		}
+	}
+
+	return nil
+}
+
+var errNotTemplatePackage = fmt.Errorf("directory has no template manifest")
+
+func loadTemplatePackage(fsys fs.FS, dir, source string, strict bool) (ProjectTemplate, error) {
+	for _, manifest := range manifestNames {
+		data, err := fs.ReadFile(fsys, path.Join(dir, manifest))
+		if err != nil {
+			continue
+		}
+
+		tmpl, err := parseTemplateManifest(manifest, data, strict)
+		if err != nil {
+			return tmpl, fmt.Errorf("%s: %w", manifest, err)
+		}
+
+		if info, err := fs.Stat(fsys, path.Join(dir, templateFilesDir)); err == nil && info.IsDir() {
+			sub, err := fs.Sub(fsys, path.Join(dir, templateFilesDir))
+			if err != nil {
+				return tmpl, err
+			}
+			tmpl.trees = []templateTree{{fsys: sub, source: path.Join(source, templateFilesDir)}}
+		}
+
+		return tmpl, loadReferences(fsys, dir, &tmpl)
+	}
+
+	return ProjectTemplate{}, errNotTemplatePackage
+}
+
+// templateFileError is an error rendering a file of a template package.
+type templateFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *templateFileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *templateFileError) Unwrap() error {
+	return e.Err
+}
+
+// renderTemplateTrees renders the files of every package tree of tmpl, later
+// trees overriding earlier ones.
+func renderTemplateTrees(tmpl ProjectTemplate, data templateData, options ...string) (map[string]templateFile, error) {
+	files := make(map[string]templateFile)
+
+	for i, tree := range tmpl.trees {
+		err := fs.WalkDir(tree.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				return nil
+			}
+
+			include, err := tmpl.includes(p, data, options...)
+			if err != nil {
+				return &templateFileError{Path: tree.source + "/" + p, Err: fmt.Errorf("condition: %w", err)}
+			}
+			if !include {
+				return nil
+			}
+
+			target, err := renderString("path", p, data, options...)
+			if err != nil {
+				return &templateFileError{Path: tree.source + "/" + p, Err: fmt.Errorf("path: %w", err)}
+			}
+
+			target = strings.Trim(path.Clean("/"+strings.TrimSpace(target)), "/")
+			target = strings.TrimSuffix(target, templateSuffix)
+			if target == "" {
+				// paths rendering to nothing are conditionally left out
+				return nil
+			}
+
+			raw, err := fs.ReadFile(tree.fsys, p)
+			if err != nil {
+				return err
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			mode := fs.FileMode(0644)
+			if info.Mode()&0111 != 0 || tmpl.isExecutable(p) {
+				mode = 0755
+			}
+
+			content := string(raw)
+			if isText(raw) {
+				content, err = renderString(p, content, data, options...)
+				if err != nil {
+					return &templateFileError{Path: tree.source + "/" + p, Err: err}
+				}
+			}
+
+			files[target] = templateFile{
+				Path:    target,
+				Content: content,
+				Mode:    mode,
+				tree:    i,
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// includes evaluates the condition declared for p, if any. A condition is a
+// template expression; the file is left out when it renders to an empty or
+// false value.
+func (tmpl ProjectTemplate) includes(p string, data templateData, options ...string) (bool, error) {
+	for pattern, condition := range tmpl.Conditions {
+		if !matchTemplatePath(pattern, p) {
+			continue
+		}
+
+		result, err := renderString("condition", condition, data, options...)
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(result)) {
+		case "", "false", "0", "no", "<no value>":
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (tmpl ProjectTemplate) isExecutable(p string) bool {
+	for _, pattern := range tmpl.Executable {
+		if matchTemplatePath(pattern, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchTemplatePath matches p against a glob pattern or a directory prefix.
+func matchTemplatePath(pattern, p string) bool {
+	pattern = strings.Trim(pattern, "/")
+
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+
+	return strings.HasPrefix(p, pattern+"/")
+}
+
+func renderString(name, content string, data templateData, options ...string) (string, error) {
+	t, err := template.New(name).Option(options...).Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
+
+// FilePaths lists the inline and package files of tmpl, before path
+// templating and conditions, sorted.
+func (tmpl ProjectTemplate) FilePaths() []string {
+	seen := make(map[string]bool)
+
+	for p := range tmpl.Files {
+		seen[p] = true
+	}
+
+	for _, tree := range tmpl.trees {
+		fs.WalkDir(tree.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				seen[strings.TrimSuffix(p, templateSuffix)] = true
+			}
+			return nil
+		})
+	}
+
+	return sortedKeys(seen)
+}
@@ -0,0 +1,191 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// TemplatePathEnv lists extra template directories, separated like PATH.
+const TemplatePathEnv = "CODEBASE_MAKER_TEMPLATES"
+
+const appDirName = "codebase-maker"
+
+// UserTemplateDir is the templates directory in the user configuration
+// directory, e.g. ~/.config/codebase-maker/templates.
+func UserTemplateDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, appDirName, "templates"), nil
+}
+
+// TemplateCacheDir is where fetched template sources are kept, one
+// directory per source, e.g. ~/.cache/codebase-maker/templates.
+func TemplateCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, appDirName, "templates"), nil
+}
+
+// DefaultTemplatePaths returns the directories searched for templates after
+// the embedded ones, lowest priority first: the fetched sources, the user
+// configuration directory, ./templates and the paths in
+// $CODEBASE_MAKER_TEMPLATES. Templates in later directories override those
+// with the same name in earlier ones. Directories that do not exist are
+// skipped when loading.
+func DefaultTemplatePaths() []string {
+	var paths []string
+
+	if cache, err := TemplateCacheDir(); err == nil {
+		if entries, err := os.ReadDir(cache); err == nil {
+			for _, entry := range entries {
+				if entry.IsDir() && validSourceName(entry.Name()) {
+					paths = append(paths, filepath.Join(cache, entry.Name()))
+				}
+			}
+		}
+	}
+
+	if dir, err := UserTemplateDir(); err == nil {
+		paths = append(paths, dir)
+	}
+
+	paths = append(paths, "./templates")
+
+	for _, p := range filepath.SplitList(os.Getenv(TemplatePathEnv)) {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+// TemplatePaths returns the directories the templates are currently loaded
+// from, lowest priority first.
+func (a *Agent) TemplatePaths() []string {
+	a.catalogMutex.RLock()
+	defer a.catalogMutex.RUnlock()
+
+	return append(DefaultTemplatePaths(), a.extraTemplatePaths...)
+}
+
+// AddTemplatePaths adds directories searched for templates after the
+// default ones and reloads the templates.
+func (a *Agent) AddTemplatePaths(paths ...string) error {
+	a.catalogMutex.Lock()
+	a.extraTemplatePaths = append(a.extraTemplatePaths, paths...)
+	a.catalogMutex.Unlock()
+
+	return a.ReloadTemplates()
+}
+
+// ReloadTemplates reads the templates, add-ons and prompt templates again.
+// The new catalog replaces the current one at once, so generations that
+// are already running keep the templates they started with.
+func (a *Agent) ReloadTemplates() error {
+	loaded := &Agent{templatePaths: a.TemplatePaths(), logger: a.logger}
+
+	if err := loaded.loadTemplates(); err != nil {
+		return err
+	}
+	loaded.loadAddons()
+	loaded.loadPromptTemplates()
+
+	a.catalogMutex.Lock()
+	defer a.catalogMutex.Unlock()
+
+	a.templatePaths = loaded.templatePaths
+	a.templates = loaded.templates
+	a.addons = loaded.addons
+	a.promptsTmpl = loaded.promptsTmpl
+
+	return nil
+}
+
+// ShareCatalog makes the agent use the templates, add-ons and prompt
+// templates loaded by other, e.g. the catalog of a server.
+func (a *Agent) ShareCatalog(other *Agent) {
+	other.catalogMutex.RLock()
+	templatePaths, templates, addons, prompts := other.templatePaths, other.templates, other.addons, other.promptsTmpl
+	other.catalogMutex.RUnlock()
+
+	a.catalogMutex.Lock()
+	defer a.catalogMutex.Unlock()
+
+	a.templatePaths = templatePaths
+	a.templates = templates
+	a.addons = addons
+	a.promptsTmpl = prompts
+}
+
+// WatchTemplates checks the template paths every interval and reloads the
+// templates when a file was added, removed or modified, until ctx is done.
+// onReload is called after every reload with its result.
+func (a *Agent) WatchTemplates(ctx context.Context, interval time.Duration, onReload func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := templatesFingerprint(a.TemplatePaths())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := templatesFingerprint(a.TemplatePaths())
+		if current == last {
+			continue
+		}
+		last = current
+
+		err := a.ReloadTemplates()
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+}
+
+// templatesFingerprint hashes the names, sizes and modification times of
+// the files under paths.
+func templatesFingerprint(paths []string) string {
+	hash := sha256.New()
+
+	for _, root := range paths {
+		fmt.Fprintf(hash, "root %s\n", root)
+
+		var lines []string
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			lines = append(lines, fmt.Sprintf("%s %d %d %s", p, info.Size(), info.ModTime().UnixNano(), info.Mode()))
+			return nil
+		})
+		sort.Strings(lines)
+
+		for _, line := range lines {
+			fmt.Fprintln(hash, line)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,218 @@
+package agents
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+// maxReferenceSize is the largest reference file sent to the model.
+const maxReferenceSize = 256 << 10
+
+// TemplateExample is a few-shot exchange: a prompt and the files expected in
+// reply. Examples are sent as they are, without template rendering.
+type TemplateExample struct {
+	Prompt string            `json:"prompt" yaml:"prompt"`
+	Files  map[string]string `json:"files" yaml:"files"`
+}
+
+// promptData is what prompt templates and template system prompts are
+// rendered with.
+type promptData struct {
+	BasePackage string
+	ProjectName string
+	ExtraPrompt string
+	Params      map[string]interface{}
+}
+
+// loadReferences reads the reference files of tmpl. Patterns are relative
+// to dir, the directory holding the template.
+func loadReferences(fsys fs.FS, dir string, tmpl *ProjectTemplate) error {
+	seen := make(map[string]bool)
+
+	for _, pattern := range tmpl.References {
+		if msg := checkFilePath(pattern); msg != "" {
+			return fmt.Errorf("reference %s: %s", pattern, msg)
+		}
+
+		matches, err := fs.Glob(fsys, path.Join(dir, path.Clean(pattern)))
+		if err != nil {
+			return fmt.Errorf("reference %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("reference %s matches no file", pattern)
+		}
+
+		for _, match := range matches {
+			err := fs.WalkDir(fsys, match, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if d.IsDir() {
+					if p != match && strings.HasPrefix(d.Name(), ".") {
+						return fs.SkipDir
+					}
+					return nil
+				}
+
+				rel := p
+				if dir != "." {
+					rel = strings.TrimPrefix(p, dir+"/")
+				}
+				if seen[rel] {
+					return nil
+				}
+				seen[rel] = true
+
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				if info.Size() > maxReferenceSize {
+					return fmt.Errorf("reference %s is larger than %d KB", rel, maxReferenceSize>>10)
+				}
+
+				data, err := fs.ReadFile(fsys, p)
+				if err != nil {
+					return err
+				}
+				if !isText(data) {
+					slog.Debug("Skipping binary reference file", logging.FileKey, rel)
+					return nil
+				}
+
+				tmpl.references = append(tmpl.references, templateFile{Path: rel, Content: string(data)})
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// systemPrompt renders the system prompt of tmpl, or the prompt template of
+// the language when the template has none, followed by the reference files.
+func (a *Agent) systemPrompt(tmpl ProjectTemplate) (string, error) {
+	source := tmpl.SystemPrompt
+
+	if source == "" {
+		a.catalogMutex.RLock()
+		promptTemplate, ok := a.promptsTmpl[a.language]
+
+		if !ok {
+			a.logger.Warn("No prompt template found, using default", logging.LanguageKey, a.language)
+
+			promptTemplate = a.promptsTmpl["default"]
+		}
+		a.catalogMutex.RUnlock()
+
+		source = promptTemplate.Template
+	}
+
+	data := promptData{
+		BasePackage: a.basePackage,
+		ProjectName: a.projectName,
+		Params:      a.params,
+	}
+
+	// the prompt of the template is a template too, e.g. naming files after
+	// {{.ProjectName}}. Prompts that are not, like those quoting Jinja or
+	// Handlebars, are used as they are, as template files are.
+	extra, err := renderPrompt(tmpl.Prompt, data)
+	if err != nil {
+		a.logger.Warn("Template prompt not processed", logging.TemplateKey, tmpl.Name, logging.ErrorKey, err)
+		if a.progressCallBack != nil {
+			a.progressCallBack("warning", "Template prompt not processed: "+err.Error(), "")
+		}
+		extra = tmpl.Prompt
+	}
+	data.ExtraPrompt = joinPrompts(extra, paramsPrompt(a.params))
+
+	prompt, err := renderPrompt(source, data)
+	if err != nil {
+		return "", err
+	}
+
+	if len(tmpl.references) > 0 {
+		prompt += "\n\n" + formatReferences(tmpl.references)
+	}
+
+	return prompt, nil
+}
+
+func renderPrompt(source string, data promptData, options ...string) (string, error) {
+	t, err := template.New("prompt").Option(options...).Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("error parsing prompt template:%w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error executing prompt template:%w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// messages builds the conversation sent for prompt: the system prompt, the
+// few-shot examples of tmpl as prior exchanges and the prompt itself,
+// followed by the context files.
+func (a *Agent) messages(tmpl ProjectTemplate, prompt string) ([]Message, error) {
+	systemPrompt, err := a.systemPrompt(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []Message{{Role: RoleSystem, Content: systemPrompt}}
+
+	for _, example := range tmpl.Examples {
+		messages = append(messages,
+			Message{Role: RoleUser, Content: example.Prompt},
+			Message{Role: RoleAssistant, Content: formatFileBlocks(example.Files)},
+		)
+	}
+
+	if len(a.contextFiles) > 0 {
+		prompt += "\n\n" + formatContext(a.contextFiles)
+	}
+
+	return append(messages, Message{Role: RoleUser, Content: prompt}), nil
+}
+
+// formatFileBlocks writes files in the format the model is asked to answer
+// with.
+func formatFileBlocks(files map[string]string) string {
+	var b strings.Builder
+
+	for _, p := range sortedKeys(files) {
+		fmt.Fprintf(&b, "---FILE_PATH: %s\n%s\n---END_FILE\n\n", p, strings.TrimSpace(files[p]))
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// formatReferences lists the reference files in a format distinct from the
+// file blocks, so they are not taken as output to reproduce.
+func formatReferences(files []templateFile) string {
+	var b strings.Builder
+
+	b.WriteString("Use the following reference files as a guide for the structure, style and conventions of the code. Do not copy them into the output unless the requirements ask for it.\n")
+
+	for _, file := range files {
+		fmt.Fprintf(&b, "\n---REFERENCE: %s\n%s\n---END_REFERENCE\n", file.Path, strings.TrimSpace(file.Content))
+	}
+
+	return b.String()
+}
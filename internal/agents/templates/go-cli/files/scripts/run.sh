@@ -0,0 +1,5 @@
+#!/bin/sh
+set -e
+
+make build
+exec ./bin/{{.ProjectName}} "$@"
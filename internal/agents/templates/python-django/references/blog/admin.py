@@ -0,0 +1,10 @@
+from django.contrib import admin
+
+from .models import Post
+
+
+@admin.register(Post)
+class PostAdmin(admin.ModelAdmin):
+    list_display = ("title", "published_at")
+    search_fields = ("title", "body")
+    date_hierarchy = "published_at"
@@ -0,0 +1,15 @@
+from django.test import TestCase
+from django.urls import reverse
+from django.utils import timezone
+
+from .models import Post
+
+
+class PostListViewTests(TestCase):
+    def test_lists_published_posts(self):
+        Post.objects.create(title="Hello", body="First post", published_at=timezone.now())
+
+        response = self.client.get(reverse("blog:post_list"))
+
+        self.assertEqual(response.status_code, 200)
+        self.assertContains(response, "Hello")
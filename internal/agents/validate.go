@@ -0,0 +1,532 @@
+package agents
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/lFer17/codebase-maker/internal/logging"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// TemplateIssue is a problem found while validating templates.
+type TemplateIssue struct {
+	Source   string `json:"source"`
+	Template string `json:"template,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (i TemplateIssue) String() string {
+	if i.Template != "" {
+		return fmt.Sprintf("%s: %s: %s: %s", i.Source, i.Severity, i.Template, i.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", i.Source, i.Severity, i.Message)
+}
+
+// TemplateReport is the result of ValidateTemplates.
+type TemplateReport struct {
+	Templates int             `json:"templates"`
+	Addons    int             `json:"addons"`
+	Prompts   int             `json:"prompts"`
+	Issues    []TemplateIssue `json:"issues"`
+}
+
+// Errors returns the number of issues with error severity.
+func (r TemplateReport) Errors() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			count++
+		}
+	}
+
+	return count
+}
+
+type templateValidator struct {
+	report    TemplateReport
+	templates map[string]templateEntry
+	addons    map[string]templateEntry
+	prompts   map[string]PromptTemplate
+	// promptSources maps languages to the file of their prompt template.
+	promptSources map[string]string
+	// embedded holds the names defined by the embedded templates, which
+	// custom templates may override.
+	embedded map[string]bool
+}
+
+// ValidateTemplates loads the embedded templates and the custom ones in
+// dirs, laid out like ./templates with add-ons in addons/ and prompt
+// templates in prompts/, and checks them: manifest fields, duplicate names,
+// languages without a prompt template, inheritance and add-ons, parameters,
+// few-shot examples, reference files, and the template syntax of files,
+// paths, conditions, prompts and system prompts,
+// rendering them with sample parameter values. Directories are loaded in
+// order like template paths; without dirs only the embedded templates are
+// checked.
+func ValidateTemplates(dirs ...string) (TemplateReport, error) {
+	v := &templateValidator{
+		templates: make(map[string]templateEntry),
+		addons:    make(map[string]templateEntry),
+		prompts:   make(map[string]PromptTemplate),
+		embedded:  make(map[string]bool),
+
+		promptSources: make(map[string]string),
+	}
+
+	for _, p := range defaultPrompts {
+		v.prompts[p.Language] = p
+		v.promptSources[p.Language] = "embedded/prompts/" + p.Language
+	}
+
+	if err := v.load(templatesFS, "templates", "embedded"); err != nil {
+		return v.report, fmt.Errorf("reading embedded templates: %w", err)
+	}
+	for name := range v.templates {
+		v.embedded["template "+name] = true
+	}
+	for name := range v.addons {
+		v.embedded["add-on "+name] = true
+	}
+
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return v.report, err
+		}
+		if !info.IsDir() {
+			return v.report, fmt.Errorf("%s is not a directory", dir)
+		}
+
+		if err := v.load(os.DirFS(dir), ".", path.Clean(dir)); err != nil {
+			return v.report, fmt.Errorf("reading %s: %w", dir, err)
+		}
+	}
+
+	v.checkPrompts()
+	v.checkTemplates()
+
+	sort.SliceStable(v.report.Issues, func(i, j int) bool {
+		return v.report.Issues[i].Source < v.report.Issues[j].Source
+	})
+
+	v.report.Templates = len(v.templates)
+	v.report.Addons = len(v.addons)
+	v.report.Prompts = len(v.prompts)
+
+	return v.report, nil
+}
+
+func (v *templateValidator) issue(severity, source, tmpl, format string, args ...interface{}) {
+	v.report.Issues = append(v.report.Issues, TemplateIssue{
+		Source:   source,
+		Template: tmpl,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *templateValidator) load(fsys fs.FS, root, source string) error {
+	entries, err := readTemplateDir(fsys, root, source, true)
+	if err != nil {
+		return err
+	}
+
+	local := make(map[string]string)
+	for _, entry := range entries {
+		v.add(v.templates, "template", entry, local)
+	}
+
+	if entries, err := readTemplateDir(fsys, path.Join(root, "addons"), source+"/addons", true); err == nil {
+		localAddons := make(map[string]string)
+		for _, entry := range entries {
+			v.add(v.addons, "add-on", entry, localAddons)
+		}
+	}
+
+	prompts, err := readPromptDir(fsys, path.Join(root, "prompts"), source+"/prompts", true)
+	if err != nil {
+		// no prompts directory
+		return nil
+	}
+
+	localPrompts := make(map[string]string)
+	for _, entry := range prompts {
+		if entry.Err != nil {
+			v.issue(SeverityError, entry.Source, "", "%v", entry.Err)
+			continue
+		}
+
+		language := entry.Prompt.Language
+		if language == "" {
+			v.issue(SeverityError, entry.Source, "", "prompt template has no language")
+			continue
+		}
+		if other, ok := localPrompts[language]; ok {
+			v.issue(SeverityError, entry.Source, "", "duplicate prompt template for language %s, also defined in %s", language, other)
+			continue
+		}
+		localPrompts[language] = entry.Source
+
+		if _, err := template.New(entry.Source).Parse(entry.Prompt.Template); err != nil {
+			v.issue(SeverityError, entry.Source, "", "%v", err)
+		}
+
+		v.prompts[language] = entry.Prompt
+		v.promptSources[language] = entry.Source
+	}
+
+	return nil
+}
+
+// add records entry in set, reporting parse errors, missing names and names
+// defined twice in the same directory.
+func (v *templateValidator) add(set map[string]templateEntry, kind string, entry templateEntry, local map[string]string) {
+	if entry.Err != nil {
+		v.issue(SeverityError, entry.Source, "", "%v", entry.Err)
+		return
+	}
+
+	name := entry.Template.Name
+	if name == "" {
+		v.issue(SeverityError, entry.Source, "", "missing name")
+		return
+	}
+
+	if other, ok := local[name]; ok {
+		v.issue(SeverityError, entry.Source, name, "duplicate name, also defined in %s", other)
+		return
+	}
+	local[name] = entry.Source
+
+	if v.embedded[kind+" "+name] {
+		v.issue(SeverityWarning, entry.Source, name, "overrides the embedded %s with the same name", kind)
+	}
+
+	set[name] = entry
+}
+
+func (v *templateValidator) checkPrompts() {
+	data := promptData{
+		BasePackage: "github.com/example/app",
+		ProjectName: "app",
+		ExtraPrompt: "- Sample prompt",
+		Params:      map[string]interface{}{},
+	}
+
+	for _, language := range sortedKeys(v.prompts) {
+		t, err := template.New("prompt " + language).Parse(v.prompts[language].Template)
+		if err != nil {
+			continue // reported when loading
+		}
+
+		if err := t.Execute(&bytes.Buffer{}, data); err != nil {
+			v.issue(SeverityError, v.promptSources[language], "", "rendering prompt template: %v", err)
+		}
+	}
+}
+
+func (v *templateValidator) checkTemplates() {
+	a := &Agent{
+		logger:    logging.Discard(),
+		templates: make(map[string]ProjectTemplate, len(v.templates)),
+		addons:    make(map[string]ProjectTemplate, len(v.addons)),
+	}
+	for name, entry := range v.templates {
+		a.templates[name] = entry.Template
+	}
+	for name, entry := range v.addons {
+		a.addons[name] = entry.Template
+	}
+
+	for _, name := range sortedKeys(v.addons) {
+		entry := v.addons[name]
+		v.checkManifest(entry, false)
+
+		if entry.Template.Language != "" && entry.Template.SystemPrompt == "" {
+			v.checkLanguage(entry)
+		}
+
+		v.render(entry, entry.Template)
+	}
+
+	for _, name := range sortedKeys(v.templates) {
+		entry := v.templates[name]
+		v.checkManifest(entry, true)
+
+		resolved, err := a.ResolveTemplate(name)
+		if err != nil {
+			v.issue(SeverityError, entry.Source, name, "%v", err)
+			continue
+		}
+
+		if resolved.Language == "" {
+			v.issue(SeverityError, entry.Source, name, "missing language")
+		} else if resolved.SystemPrompt == "" {
+			entry.Template.Language = resolved.Language
+			v.checkLanguage(entry)
+		}
+
+		v.render(entry, resolved)
+	}
+}
+
+// checkManifest checks the fields of a template as written, before
+// inheritance and add-ons are applied.
+func (v *templateValidator) checkManifest(entry templateEntry, isTemplate bool) {
+	tmpl := entry.Template
+
+	if tmpl.Description == "" {
+		v.issue(SeverityWarning, entry.Source, tmpl.Name, "missing description")
+	}
+
+	if isTemplate && tmpl.Prompt == "" && tmpl.SystemPrompt == "" && len(tmpl.Examples) == 0 && tmpl.Extends == "" && len(tmpl.Mixins) == 0 && len(tmpl.Files) == 0 && len(tmpl.trees) == 0 {
+		v.issue(SeverityWarning, entry.Source, tmpl.Name, "template has no prompt and no files")
+	}
+
+	for p := range tmpl.Files {
+		if msg := checkFilePath(p); msg != "" {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "file %q: %s", p, msg)
+		}
+	}
+
+	// prompts with literal braces, like Jinja or Handlebars examples, are
+	// sent as they are
+	if _, err := template.New("prompt").Parse(tmpl.Prompt); err != nil {
+		v.issue(SeverityWarning, entry.Source, tmpl.Name, "prompt is not a valid template, it is used as is: %v", err)
+	}
+
+	if tmpl.SystemPrompt != "" {
+		if _, err := template.New("system prompt").Parse(tmpl.SystemPrompt); err != nil {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "system prompt: %v", err)
+		}
+	}
+
+	for i, example := range tmpl.Examples {
+		if strings.TrimSpace(example.Prompt) == "" {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "example %d has no prompt", i+1)
+		}
+		if len(example.Files) == 0 {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "example %d has no files", i+1)
+		}
+		for p := range example.Files {
+			if msg := checkFilePath(p); msg != "" {
+				v.issue(SeverityError, entry.Source, tmpl.Name, "example %d: file %q: %s", i+1, p, msg)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range tmpl.Parameters {
+		if p.Name == "" {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "parameter without a name")
+			continue
+		}
+		if seen[p.Name] {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "parameter %s declared twice", p.Name)
+		}
+		seen[p.Name] = true
+
+		switch p.kind() {
+		case ParamString, ParamInt, ParamBool:
+		case ParamEnum:
+			if len(p.Enum) == 0 {
+				v.issue(SeverityError, entry.Source, tmpl.Name, "parameter %s: enum without values", p.Name)
+			}
+		default:
+			v.issue(SeverityError, entry.Source, tmpl.Name, "parameter %s: unknown type %q, expected one of %s", p.Name, p.Type, strings.Join([]string{ParamString, ParamInt, ParamBool, ParamEnum}, ", "))
+		}
+
+		if p.Default != nil {
+			if _, err := p.convert(fmt.Sprint(p.Default)); err != nil {
+				v.issue(SeverityError, entry.Source, tmpl.Name, "default value: %v", err)
+			}
+		}
+	}
+
+	if err := tmpl.Sampling.Validate(); err != nil {
+		v.issue(SeverityError, entry.Source, tmpl.Name, "sampling: %v", err)
+	}
+
+	for pattern, condition := range tmpl.Conditions {
+		if _, err := template.New("condition").Parse(condition); err != nil {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "condition for %s: %v", pattern, err)
+		}
+		if !tmpl.matchesAny(pattern) {
+			v.issue(SeverityWarning, entry.Source, tmpl.Name, "condition for %s matches no file", pattern)
+		}
+	}
+
+	for _, pattern := range tmpl.Executable {
+		if !tmpl.matchesAny(pattern) {
+			v.issue(SeverityWarning, entry.Source, tmpl.Name, "executable pattern %s matches no file", pattern)
+		}
+	}
+}
+
+func (v *templateValidator) checkLanguage(entry templateEntry) {
+	language := entry.Template.Language
+
+	if _, ok := v.prompts[language]; ok {
+		return
+	}
+
+	known := sortedKeys(v.prompts)
+	for _, l := range known {
+		if strings.EqualFold(l, language) {
+			v.issue(SeverityError, entry.Source, entry.Template.Name, "language %q has no prompt template, did you mean %q?", language, l)
+			return
+		}
+	}
+
+	v.issue(SeverityError, entry.Source, entry.Template.Name, "language %q has no prompt template (known: %s)", language, strings.Join(known, ", "))
+}
+
+// render renders the files, paths and conditions of tmpl with sample
+// parameter values, failing on references to undeclared parameters.
+func (v *templateValidator) render(entry templateEntry, tmpl ProjectTemplate) {
+	// invalid parameters are reported by checkManifest, render with the
+	// values that resolve
+	params, _ := ResolveParams(tmpl.Parameters, sampleParams(tmpl.Parameters))
+
+	data := templateData{
+		Package:     "github.com/example/app",
+		ProjectName: "app",
+		Params:      params,
+	}
+
+	prompt := promptData{
+		BasePackage: data.Package,
+		ProjectName: data.ProjectName,
+		Params:      params,
+	}
+
+	// syntax errors are reported by checkManifest, for the template holding
+	// the prompt
+	extra := tmpl.Prompt
+	if _, err := template.New("prompt").Parse(tmpl.Prompt); err == nil {
+		if rendered, err := renderPrompt(tmpl.Prompt, prompt, "missingkey=error"); err != nil {
+			v.issue(SeverityWarning, entry.Source, tmpl.Name, "prompt cannot be rendered, it is used as is: %v", err)
+		} else {
+			extra = rendered
+		}
+	}
+	prompt.ExtraPrompt = joinPrompts(extra, paramsPrompt(params))
+
+	if tmpl.SystemPrompt != "" {
+		if _, err := renderPrompt(tmpl.SystemPrompt, prompt, "missingkey=error"); err != nil {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "system prompt: %v", err)
+		}
+	}
+
+	for _, p := range sortedKeys(tmpl.Files) {
+		if _, err := renderString(p, tmpl.Files[p], data, "missingkey=error"); err != nil {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "%v", err)
+		}
+	}
+
+	files, err := renderTemplateTrees(tmpl, data, "missingkey=error")
+	if err != nil {
+		var fileErr *templateFileError
+		if errors.As(err, &fileErr) {
+			v.issue(SeverityError, fileErr.Path, tmpl.Name, "%v", fileErr.Err)
+		} else {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "%v", err)
+		}
+		return
+	}
+
+	for _, p := range sortedKeys(files) {
+		if msg := checkFilePath(p); msg != "" {
+			v.issue(SeverityError, entry.Source, tmpl.Name, "file %q: %s", p, msg)
+		}
+	}
+}
+
+// sampleParams returns a value for every parameter: its default or a
+// placeholder of its type.
+func sampleParams(schema []TemplateParameter) map[string]string {
+	values := make(map[string]string, len(schema))
+
+	for _, p := range schema {
+		switch {
+		case p.Default != nil:
+			continue
+		case len(p.Enum) > 0:
+			values[p.Name] = p.Enum[0]
+		case p.kind() == ParamInt:
+			values[p.Name] = "1"
+		case p.kind() == ParamBool:
+			values[p.Name] = "true"
+		case p.kind() == ParamString:
+			values[p.Name] = "example"
+		}
+	}
+
+	return values
+}
+
+// checkFilePath reports paths that would be written outside the project.
+func checkFilePath(p string) string {
+	switch {
+	case p == "":
+		return "empty path"
+	case path.IsAbs(p) || strings.HasPrefix(p, "\\") || strings.Contains(p, ":"):
+		return "path must be relative to the project"
+	case slices.Contains(strings.Split(strings.ReplaceAll(p, "\\", "/"), "/"), ".."):
+		return "path must not leave the project"
+	}
+
+	return ""
+}
+
+// matchesAny reports whether pattern matches an inline file or a file of the
+// package trees, before path templating.
+func (tmpl ProjectTemplate) matchesAny(pattern string) bool {
+	for p := range tmpl.Files {
+		if matchTemplatePath(pattern, p) {
+			return true
+		}
+	}
+
+	for _, tree := range tmpl.trees {
+		found := false
+		fs.WalkDir(tree.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || found {
+				return fs.SkipAll
+			}
+			if p != "." && matchTemplatePath(pattern, p) {
+				found = true
+			}
+			return nil
+		})
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
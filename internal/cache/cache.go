@@ -0,0 +1,219 @@
+// Package cache stores model responses on disk, addressed by the hash of
+// the request that produced them, so identical requests are answered
+// without calling the model again. Entries expire after a TTL and the
+// least recently used ones are removed when the cache grows past its size
+// limit.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	appDirName = "codebase-maker"
+	entryExt   = ".json"
+)
+
+// Cache is a directory of entries named by their key. It is safe for
+// concurrent use, also by several processes sharing the directory.
+type Cache struct {
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+	mu       sync.Mutex
+}
+
+// DefaultDir is the response cache in the user cache directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, appDirName, "responses"), nil
+}
+
+// New returns the cache in dir, DefaultDir when empty. A zero ttl keeps
+// entries until they are evicted and a zero maxBytes leaves the size
+// unbounded. The directory is created on the first Put.
+func New(dir string, ttl time.Duration, maxBytes int64) (*Cache, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return nil, fmt.Errorf("finding the cache directory:%w", err)
+		}
+	}
+
+	return &Cache{dir: dir, ttl: ttl, maxBytes: maxBytes}, nil
+}
+
+// Dir returns the directory of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Key returns the content address of parts: the hex SHA-256 of each part,
+// length prefixed so that different splits never collide.
+func Key(parts ...[]byte) string {
+	h := sha256.New()
+
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:", len(part))
+		h.Write(part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+entryExt)
+}
+
+// Get returns the entry of key, if it exists and has not expired. Reading
+// an entry marks it as recently used.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if len(key) < 2 {
+		return nil, false
+	}
+
+	path := c.path(key)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	// the access time is not reliable, so the modification time tracks use;
+	// the ttl then counts from the last use
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return data, true
+}
+
+// Put stores data under key, then evicts the least recently used entries
+// if the cache is over its size limit.
+func (c *Cache) Put(key string, data []byte) error {
+	if len(key) < 2 {
+		return errors.New("invalid cache key")
+	}
+
+	path := c.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating cache directory:%w", err)
+	}
+
+	// write then rename, so readers never see partial entries
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing cache entry:%w", err)
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache entry:%w", err)
+	}
+
+	return c.prune()
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// prune removes the expired entries, then the least recently used ones
+// until the cache fits in maxBytes.
+func (c *Cache) prune() error {
+	if c.ttl == 0 && c.maxBytes == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		entries []entry
+		total   int64
+	)
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// entries removed by another process while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(d.Name(), entryExt) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+			os.Remove(path)
+			return nil
+		}
+
+		entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("pruning cache:%w", err)
+	}
+
+	if c.maxBytes == 0 || total <= c.maxBytes {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			total -= e.size
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+// Package config resolves the settings shared by the maker CLI and the
+// server from layered sources, lowest priority first: defaults, the user
+// config file, the project config file, .env, environment variables and
+// flags. Config files can define named profiles that are applied on top of
+// the file holding them.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ProjectFile is the project-local config file, looked up from the
+	// working directory upwards.
+	ProjectFile = ".codebase-maker.yaml"
+	// FileEnv overrides the path of the user config file.
+	FileEnv = "CODEBASE_MAKER_CONFIG"
+	// ProfileEnv selects a profile when --profile is not given.
+	ProfileEnv = "CODEBASE_MAKER_PROFILE"
+
+	envPrefix  = "CODEBASE_MAKER_"
+	appDirName = "codebase-maker"
+)
+
+// Config holds every setting. The tags describe each setting: key is its
+// name in config files, env lists extra environment variables besides
+// CODEBASE_MAKER_<KEY>, flag overrides the flag name derived from the key,
+// secret hides the value in config show and sep splits list values. trusted
+// settings decide where keys, prompts and job data are sent or where files
+// are written and read, so project files and .env, which come with the
+// repositories users clone, cannot set them.
+type Config struct {
+	Provider    string `key:"provider" default:"openai" usage:"Model provider (only openai is supported)"`
+	APIKey      string `key:"api_key" env:"OPENAI_KEY" flag:"openai-key" secret:"true" trusted:"true" usage:"OpenAI API key"`
+	BaseURL     string `key:"base_url" env:"OPENAI_BASE_URL" default:"https://api.openai.com/v1" trusted:"true" usage:"Base URL of the OpenAI compatible API"`
+	Model       string `key:"model" env:"OPENAI_MODEL" default:"gpt-4o-mini" usage:"OpenAI model to use"`
+	Timeout     int    `key:"timeout" default:"120" usage:"Timeout in seconds for OpenAI API calls"`
+	Retries     int    `key:"retries" default:"2" usage:"Retries of API calls failing with network, rate limit or server errors"`
+	BasePackage string `key:"base_package" default:"github.com/user/app" usage:"Base package for generated files"`
+	Template    string `key:"template" default:"default" usage:"Template to use"`
+	Language    string `key:"language" default:"go" usage:"Programming language of the project"`
+	OutputDir   string `key:"output_dir" env:"OUTPUT_DIR" default:"./output" trusted:"true" usage:"Output directory for generated files"`
+	WorkerCount int    `key:"worker_count" env:"WORKER_COUNT" default:"4" usage:"Number of concurrent workers writing files"`
+	// TemplatesPath are searched for templates after the default paths.
+	TemplatesPath []string `key:"templates_path" sep:"path" trusted:"true" usage:"Extra template directories, separated like PATH, searched after the default ones"`
+
+	Sampling SamplingConfig `key:"sampling"`
+	Log      LogConfig      `key:"log"`
+	Trace    TraceConfig    `key:"trace"`
+	Cache    CacheConfig    `key:"cache"`
+	Server   ServerConfig   `key:"server"`
+}
+
+// SamplingConfig holds the sampling parameters sent to the model. Empty
+// values leave the parameter to the template or the API, which is why the
+// numbers that can be 0 are strings.
+type SamplingConfig struct {
+	Temperature     string   `key:"temperature" usage:"Sampling temperature between 0 and 2 (default: the template's, or the API's)"`
+	TopP            string   `key:"top_p" usage:"Nucleus sampling probability mass, greater than 0 and at most 1"`
+	MaxTokens       int      `key:"max_tokens" usage:"Maximum tokens of the response, sent as max_completion_tokens to o-series models (0 leaves it unset)"`
+	Seed            string   `key:"seed" usage:"Seed for reproducible sampling"`
+	Stop            []string `key:"stop" sep:"," usage:"Sequence where the model stops generating (repeatable, at most 4)"`
+	ReasoningEffort string   `key:"reasoning_effort" usage:"Reasoning effort of o-series models: low, medium or high"`
+}
+
+// LogConfig holds the settings of the structured logs.
+type LogConfig struct {
+	Format string `key:"format" flag:"log-format" default:"text" usage:"Log format: text or json"`
+	Level  string `key:"level" flag:"log-level" default:"info" usage:"Minimum log level: debug, info, warn or error"`
+	Redact string `key:"redact" flag:"log-redact" default:"prompts" trusted:"true" usage:"What logs hide: prompts (secrets and prompts), secrets, or none"`
+}
+
+// TraceConfig holds the settings of the generation traces.
+type TraceConfig struct {
+	Exporter string `key:"exporter" flag:"trace-exporter" default:"none" trusted:"true" usage:"Where spans are exported: none, stdout, stderr or file"`
+	File     string `key:"file" flag:"trace-file" default:"traces.jsonl" trusted:"true" usage:"File the file exporter appends spans to, one JSON object per line"`
+}
+
+// CacheConfig holds the settings of the model response cache.
+type CacheConfig struct {
+	Disabled bool          `key:"disabled" flag:"no-cache" usage:"Always query the model, without reading or writing the response cache"`
+	Dir      string        `key:"dir" flag:"cache-dir" trusted:"true" usage:"Directory of the response cache (default: codebase-maker/responses in the user cache directory)"`
+	TTL      time.Duration `key:"ttl" flag:"cache-ttl" default:"168h" usage:"How long an unused cached response is kept (0 keeps it until evicted by size)"`
+	MaxSize  int           `key:"max_size" flag:"cache-max-size" default:"100" usage:"Maximum size of the response cache in MB (0 disables the limit)"`
+}
+
+// ServerConfig holds the settings of the web server.
+type ServerConfig struct {
+	Port                 string        `key:"port" default:"3000" usage:"Server port"`
+	StaticDir            string        `key:"static_dir" default:"web/static" usage:"Directory with the web UI"`
+	RateLimit            int           `key:"rate_limit" default:"10" usage:"Maximum generation requests per minute per client (0 disables)"`
+	DailyTokenBudget     int           `key:"daily_token_budget" usage:"Maximum tokens per client per day (0 disables)"`
+	DailyCostBudget      float64       `key:"daily_cost_budget" usage:"Maximum estimated USD spend per client per day (0 disables)"`
+	MaxPromptLength      int           `key:"max_prompt_length" default:"20000" usage:"Maximum prompt length in characters (0 disables)"`
+	MaxWorkers           int           `key:"max_workers" default:"8" usage:"Maximum worker count a client can request (0 disables)"`
+	TrustProxy           bool          `key:"trust_proxy" usage:"Identify clients by the last X-Forwarded-For address, the one added by the proxy"`
+	ReadTimeout          time.Duration `key:"read_timeout" default:"30s" usage:"Maximum duration for reading a request"`
+	WriteTimeout         time.Duration `key:"write_timeout" default:"5m" usage:"Maximum duration for writing a response"`
+	IdleTimeout          time.Duration `key:"idle_timeout" default:"2m" usage:"Maximum time to keep idle connections open"`
+	ShutdownTimeout      time.Duration `key:"shutdown_timeout" default:"1m" usage:"Time running generations get to finish on shutdown"`
+	PublicURL            string        `key:"public_url" usage:"Externally visible base URL used in webhook download links"`
+	WebhookSecret        string        `key:"webhook_secret" env:"WEBHOOK_SECRET" secret:"true" usage:"Secret used to sign webhook payloads"`
+	Webhooks             []string      `key:"webhooks" flag:"webhook" sep:"," trusted:"true" usage:"URL notified when any generation completes or fails (repeatable)"`
+	AllowRequestWebhooks bool          `key:"allow_request_webhooks" usage:"Accept webhook URLs sent by clients in generation requests, if they resolve to public addresses"`
+	TemplatesReload      time.Duration `key:"templates_reload" default:"2s" usage:"How often template directories are checked for changes (0 disables hot reload)"`
+}
+
+// Keys of the settings each program exposes as flags.
+var (
+	LogKeys   = []string{"log.format", "log.level", "log.redact"}
+	TraceKeys = []string{"trace.exporter", "trace.file"}
+	CacheKeys = []string{"cache.disabled", "cache.dir", "cache.ttl", "cache.max_size"}
+
+	SamplingKeys = []string{"sampling.temperature", "sampling.top_p", "sampling.max_tokens", "sampling.seed", "sampling.stop", "sampling.reasoning_effort"}
+
+	ModelKeys = append(append(append([]string{"provider", "api_key", "base_url", "model", "timeout", "retries", "worker_count", "templates_path"}, SamplingKeys...), LogKeys...), TraceKeys...)
+
+	// EditKeys are ModelKeys plus the response cache, which chat sessions
+	// do not use.
+	EditKeys = append(append([]string{}, ModelKeys...), CacheKeys...)
+
+	GenerateKeys = append(append([]string{}, EditKeys...), "output_dir", "base_package", "template", "language")
+
+	ServerKeys = []string{
+		"provider", "api_key", "base_url", "retries", "output_dir", "templates_path",
+		"server.port", "server.static_dir", "server.rate_limit", "server.daily_token_budget",
+		"server.daily_cost_budget", "server.max_prompt_length", "server.max_workers",
+		"server.trust_proxy", "server.read_timeout", "server.write_timeout", "server.idle_timeout",
+		"server.shutdown_timeout", "server.public_url", "server.webhook_secret", "server.webhooks",
+		"server.allow_request_webhooks", "server.templates_reload",
+		"log.format", "log.level", "log.redact", "trace.exporter", "trace.file",
+		"cache.disabled", "cache.dir", "cache.ttl", "cache.max_size",
+	}
+)
+
+// Setting describes one configuration key.
+type Setting struct {
+	Key     string
+	Env     []string
+	Flag    string
+	Usage   string
+	Default string
+	Secret  bool
+	// Trusted settings cannot be set in project files.
+	Trusted bool
+	sep     string
+	index   []int
+}
+
+var settings = collectSettings(reflect.TypeOf(Config{}), "", nil)
+
+// Settings returns every setting, in the order of the Config fields.
+func Settings() []Setting {
+	return append([]Setting{}, settings...)
+}
+
+func lookupSetting(key string) (Setting, bool) {
+	for _, s := range settings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+
+	return Setting{}, false
+}
+
+func collectSettings(t reflect.Type, prefix string, index []int) []Setting {
+	var result []Setting
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + field.Tag.Get("key")
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			result = append(result, collectSettings(field.Type, key+".", fieldIndex)...)
+			continue
+		}
+
+		s := Setting{
+			Key:     key,
+			Env:     []string{envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))},
+			Flag:    field.Tag.Get("flag"),
+			Usage:   field.Tag.Get("usage"),
+			Default: field.Tag.Get("default"),
+			Secret:  field.Tag.Get("secret") == "true",
+			Trusted: field.Tag.Get("trusted") == "true",
+			sep:     field.Tag.Get("sep"),
+			index:   fieldIndex,
+		}
+
+		if env := field.Tag.Get("env"); env != "" {
+			s.Env = append(s.Env, strings.Split(env, ",")...)
+		}
+
+		if s.Flag == "" {
+			name := key
+			if i := strings.LastIndexByte(name, '.'); i >= 0 {
+				name = name[i+1:]
+			}
+			s.Flag = strings.ReplaceAll(name, "_", "-")
+		}
+
+		result = append(result, s)
+	}
+
+	return result
+}
+
+// split turns a raw list value into its items.
+func (s Setting) split(raw string) []string {
+	sep := s.sep
+	if sep == "path" {
+		sep = string(os.PathListSeparator)
+	}
+
+	var items []string
+	for _, item := range strings.Split(raw, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// set parses raw into the setting field of cfg. List settings are replaced,
+// unless appending.
+func (s Setting) set(cfg *Config, raw string, appending bool) error {
+	field := reflect.ValueOf(cfg).Elem().FieldByIndex(s.index)
+
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case int:
+		v, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", s.Key, raw)
+		}
+		field.SetInt(int64(v))
+	case float64:
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", s.Key, raw)
+		}
+		field.SetFloat(v)
+	case bool:
+		v, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", s.Key, raw)
+		}
+		field.SetBool(v)
+	case time.Duration:
+		v, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration (e.g. 30s, 5m)", s.Key, raw)
+		}
+		field.SetInt(int64(v))
+	case []string:
+		items := s.split(raw)
+		if appending {
+			items = append(field.Interface().([]string), items...)
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", s.Key, field.Type())
+	}
+
+	return nil
+}
+
+// Get returns the value of the setting in cfg, formatted like in config
+// files.
+func (s Setting) Get(cfg *Config) string {
+	field := reflect.ValueOf(cfg).Elem().FieldByIndex(s.index)
+
+	switch v := field.Interface().(type) {
+	case []string:
+		sep := s.sep
+		if sep == "path" {
+			sep = string(os.PathListSeparator)
+		}
+		return strings.Join(v, sep)
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// isBool reports whether the setting is a boolean, which as a flag takes no
+// value.
+func (s Setting) isBool() bool {
+	return reflect.TypeOf(Config{}).FieldByIndex(s.index).Type.Kind() == reflect.Bool
+}
+
+func (s Setting) isList() bool {
+	return reflect.TypeOf(Config{}).FieldByIndex(s.index).Type.Kind() == reflect.Slice
+}
+
+// UserFile returns the path of the user config file, e.g.
+// ~/.config/codebase-maker/config.yaml, or $CODEBASE_MAKER_CONFIG.
+func UserFile() (string, error) {
+	if path := os.Getenv(FileEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, appDirName, "config.yaml"), nil
+}
+
+// FindProjectFile looks for ProjectFile in dir and its parents.
+func FindProjectFile(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for {
+		path := filepath.Join(dir, ProjectFile)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
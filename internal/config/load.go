@@ -0,0 +1,387 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Resolved is a loaded configuration with the source of every value.
+type Resolved struct {
+	Config *Config
+	// Sources maps setting keys to where their value comes from: default,
+	// a config file, .env, env NAME or flag -name.
+	Sources map[string]string
+	// Profile is the selected profile, if any.
+	Profile string
+	// Files are the config files that were read.
+	Files []string
+}
+
+// Loader resolves the configuration. Bind its flags to a flag set, parse
+// the arguments and call Load.
+type Loader struct {
+	// Dir is where the project config file and .env are looked up,
+	// defaulting to the working directory.
+	Dir string
+
+	profile    string
+	flagValues map[string][]string
+	flagNames  map[string]string
+}
+
+func NewLoader() *Loader {
+	return &Loader{
+		Dir:        ".",
+		flagValues: make(map[string][]string),
+		flagNames:  make(map[string]string),
+	}
+}
+
+// BindFlags defines -profile and a flag for each of the settings in keys.
+// Flags are validated when parsed but only applied by Load, on top of the
+// other sources.
+func (l *Loader) BindFlags(fs *flag.FlagSet, keys ...string) {
+	fs.StringVar(&l.profile, "profile", "", "Configuration profile to use (default: $"+ProfileEnv+")")
+
+	for _, key := range keys {
+		s, ok := lookupSetting(key)
+		if !ok {
+			panic("config: unknown setting " + key)
+		}
+
+		l.flagNames[key] = s.Flag
+		fs.Var(&settingFlag{setting: s, loader: l}, s.Flag, s.Usage)
+	}
+}
+
+// settingFlag is the flag.Value of a setting.
+type settingFlag struct {
+	setting Setting
+	loader  *Loader
+}
+
+func (f *settingFlag) String() string {
+	if f == nil || f.loader == nil {
+		return ""
+	}
+
+	return f.setting.Default
+}
+
+func (f *settingFlag) Set(raw string) error {
+	var scratch Config
+	if err := f.setting.set(&scratch, raw, false); err != nil {
+		return err
+	}
+
+	f.loader.flagValues[f.setting.Key] = append(f.loader.flagValues[f.setting.Key], raw)
+
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool {
+	return f.setting.isBool()
+}
+
+// configFile is a parsed config file: its settings and profiles, flattened
+// to setting keys.
+type configFile struct {
+	path     string
+	values   map[string]string
+	profiles map[string]map[string]string
+	profile  string
+}
+
+// Load resolves the configuration from its sources.
+func (l *Loader) Load() (*Resolved, error) {
+	resolved := &Resolved{
+		Config:  &Config{},
+		Sources: make(map[string]string),
+	}
+
+	for _, s := range settings {
+		resolved.Sources[s.Key] = "default"
+		if s.Default == "" {
+			continue
+		}
+		if err := s.set(resolved.Config, s.Default, false); err != nil {
+			return nil, fmt.Errorf("default %w", err)
+		}
+	}
+
+	var files []*configFile
+
+	if path, err := UserFile(); err == nil {
+		file, err := readConfigFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+	}
+
+	if path, ok := FindProjectFile(l.Dir); ok {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := file.checkUntrusted(); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	resolved.Profile = l.profile
+	if resolved.Profile == "" {
+		resolved.Profile = os.Getenv(ProfileEnv)
+	}
+	for i := len(files) - 1; i >= 0 && resolved.Profile == ""; i-- {
+		resolved.Profile = files[i].profile
+	}
+
+	profileFound := false
+
+	for _, file := range files {
+		resolved.Files = append(resolved.Files, file.path)
+
+		if err := resolved.apply(file.values, file.path); err != nil {
+			return nil, err
+		}
+
+		if values, ok := file.profiles[resolved.Profile]; ok && resolved.Profile != "" {
+			profileFound = true
+			if err := resolved.apply(values, fmt.Sprintf("%s (profile %s)", file.path, resolved.Profile)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if resolved.Profile != "" && !profileFound {
+		return nil, fmt.Errorf("profile %q not found in the config files", resolved.Profile)
+	}
+
+	dotenv, err := godotenv.Read(filepath.Join(l.Dir, ".env"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+
+	if err := resolved.applyEnv(func(name string) (string, bool) {
+		value, ok := dotenv[name]
+		return value, ok
+	}, ".env", untrustedOrKey); err != nil {
+		return nil, err
+	}
+
+	if err := resolved.applyEnv(os.LookupEnv, "env", nil); err != nil {
+		return nil, err
+	}
+
+	for _, key := range sortedKeys(l.flagValues) {
+		s, _ := lookupSetting(key)
+		for i, raw := range l.flagValues[key] {
+			if err := s.set(resolved.Config, raw, i > 0); err != nil {
+				return nil, err
+			}
+		}
+		resolved.Sources[key] = "flag -" + l.flagNames[key]
+	}
+
+	if resolved.Config.Provider != "openai" {
+		return nil, fmt.Errorf("provider %q is not supported, use openai with base_url for OpenAI compatible APIs", resolved.Config.Provider)
+	}
+
+	return resolved, nil
+}
+
+func (r *Resolved) apply(values map[string]string, source string) error {
+	for _, key := range sortedKeys(values) {
+		s, _ := lookupSetting(key)
+		if err := s.set(r.Config, values[key], false); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		r.Sources[key] = source
+	}
+
+	return nil
+}
+
+// applyEnv sets the settings found by lookup under one of their
+// environment variable names, the first name set winning. When allowed is
+// not nil, only the settings it accepts are read.
+func (r *Resolved) applyEnv(lookup func(string) (string, bool), source string, allowed func(Setting) bool) error {
+	for _, s := range settings {
+		if allowed != nil && !allowed(s) {
+			continue
+		}
+
+		for _, name := range s.Env {
+			value, ok := lookup(name)
+			if !ok || value == "" {
+				continue
+			}
+
+			if err := s.set(r.Config, value, false); err != nil {
+				return fmt.Errorf("%s %s: %w", source, name, err)
+			}
+			r.Sources[s.Key] = source + " " + name
+			break
+		}
+	}
+
+	return nil
+}
+
+// untrustedOrKey accepts the settings .env can set. Like project files, .env
+// comes with the repositories users clone, so trusted settings in it are
+// ignored; the API key is the exception, since keeping it in .env is the
+// documented setup and a foreign key does not leak the user's own.
+func untrustedOrKey(s Setting) bool {
+	return !s.Trusted || s.Key == "api_key"
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	file := &configFile{
+		path:     path,
+		values:   make(map[string]string),
+		profiles: make(map[string]map[string]string),
+	}
+
+	if profile, ok := doc["profile"]; ok {
+		file.profile = fmt.Sprint(profile)
+		delete(doc, "profile")
+	}
+
+	if profiles, ok := doc["profiles"]; ok {
+		named, ok := profiles.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: profiles must map profile names to settings", path)
+		}
+
+		for name, values := range named {
+			settings, ok := values.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: profile %s must hold settings", path, name)
+			}
+
+			file.profiles[name] = make(map[string]string)
+			if err := flatten("", settings, file.profiles[name]); err != nil {
+				return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+			}
+		}
+
+		delete(doc, "profiles")
+	}
+
+	if err := flatten("", doc, file.values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// checkUntrusted fails if the file, found in a project the user may not
+// control, sets a trusted setting. Otherwise a cloned repository could, say,
+// point base_url at its own host and receive the API key of the user.
+func (f *configFile) checkUntrusted() error {
+	check := func(values map[string]string, where string) error {
+		for _, key := range sortedKeys(values) {
+			if s, _ := lookupSetting(key); s.Trusted {
+				return fmt.Errorf("%s%s: %s cannot be set in a project config file, set it in %s, an environment variable or a flag", f.path, where, key, userFileHint())
+			}
+		}
+		return nil
+	}
+
+	if err := check(f.values, ""); err != nil {
+		return err
+	}
+
+	for _, name := range sortedKeys(f.profiles) {
+		if err := check(f.profiles[name], " (profile "+name+")"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func userFileHint() string {
+	if path, err := UserFile(); err == nil {
+		return path
+	}
+
+	return "the user config file"
+}
+
+// flatten turns nested YAML maps into setting keys joined with dots, and
+// lists into the separated form of list settings.
+func flatten(prefix string, doc map[string]interface{}, out map[string]string) error {
+	for name, value := range doc {
+		key := prefix + name
+
+		if nested, ok := value.(map[string]interface{}); ok {
+			if err := flatten(key+".", nested, out); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := lookupSetting(key)
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+
+		switch v := value.(type) {
+		case nil:
+			out[key] = ""
+		case []interface{}:
+			if !s.isList() {
+				return fmt.Errorf("%s: expected a single value, not a list", key)
+			}
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			sep := s.sep
+			if sep == "path" {
+				sep = string(os.PathListSeparator)
+			}
+			out[key] = strings.Join(items, sep)
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,142 @@
+// Package logging builds the slog loggers of the CLI and the server, with
+// the attribute keys they share and the redaction of secrets and prompts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Formats of the log handler.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redaction levels, from the most to the least strict.
+const (
+	// RedactPrompts hides secrets and the prompts sent to the model.
+	RedactPrompts = "prompts"
+	// RedactSecrets hides secrets only.
+	RedactSecrets = "secrets"
+	// RedactNone logs everything, for local debugging.
+	RedactNone = "none"
+)
+
+// Attribute keys shared by the agent, the OpenAI client and the server.
+const (
+	SessionKey  = "session_id"
+	TemplateKey = "template"
+	LanguageKey = "language"
+	ModelKey    = "model"
+	WorkerKey   = "worker_id"
+	FileKey     = "file"
+	PromptKey   = "prompt"
+	ErrorKey    = "error"
+	SourceKey   = "source"
+)
+
+const redacted = "[REDACTED]"
+
+// secretKeys are attributes whose value is always hidden, unless redaction
+// is off.
+var secretKeys = map[string]bool{
+	"api_key":        true,
+	"apikey":         true,
+	"authorization":  true,
+	"password":       true,
+	"secret":         true,
+	"webhook_secret": true,
+}
+
+// secretPattern matches API keys and bearer tokens inside messages and
+// values.
+var secretPattern = regexp.MustCompile(`sk-[A-Za-z0-9_-]{8,}|(?i:bearer)\s+[A-Za-z0-9._~+/=-]+`)
+
+// Options configure a logger.
+type Options struct {
+	// Format is FormatText or FormatJSON.
+	Format string
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string
+	// Redact is one of the redaction levels, RedactPrompts by default.
+	Redact string
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	var level slog.Level
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return nil, fmt.Errorf("log level %q is not one of debug, info, warn or error", opts.Level)
+		}
+	}
+
+	redact := opts.Redact
+	if redact == "" {
+		redact = RedactPrompts
+	}
+	if redact != RedactPrompts && redact != RedactSecrets && redact != RedactNone {
+		return nil, fmt.Errorf("log redaction %q is not one of %s, %s or %s", redact, RedactPrompts, RedactSecrets, RedactNone)
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			return redactAttr(redact, a)
+		},
+	}
+
+	switch opts.Format {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("log format %q is not one of %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+}
+
+func redactAttr(redact string, a slog.Attr) slog.Attr {
+	if redact == RedactNone {
+		return a
+	}
+
+	key := strings.ToLower(a.Key)
+
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, redacted)
+	case key == PromptKey && redact == RedactPrompts:
+		return slog.String(a.Key, fmt.Sprintf("[REDACTED %d chars]", len(a.Value.String())))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); secretPattern.MatchString(s) {
+			return slog.String(a.Key, secretPattern.ReplaceAllString(s, redacted))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok && secretPattern.MatchString(err.Error()) {
+			return slog.String(a.Key, secretPattern.ReplaceAllString(err.Error(), redacted))
+		}
+	}
+
+	return a
+}
+
+// Discard returns a logger dropping every record.
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporters of OpenExporter.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterStderr = "stderr"
+	ExporterFile   = "file"
+)
+
+// WriterExporter writes each span as a JSON object on its own line.
+type WriterExporter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewWriterExporter returns an exporter writing to w. Closing it does not
+// close w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+// NewFileExporter returns an exporter appending to the file at path,
+// created if missing.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening trace file:%w", err)
+	}
+
+	return &WriterExporter{enc: json.NewEncoder(f), closer: f}, nil
+}
+
+func (e *WriterExporter) ExportSpan(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.enc.Encode(span)
+}
+
+func (e *WriterExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+
+	return e.closer.Close()
+}
+
+// OpenExporter returns the exporter named by kind: none (or empty) for no
+// tracing, stdout, stderr, or file writing to path. The exporter is nil
+// when tracing is off.
+func OpenExporter(kind, path string) (Exporter, error) {
+	switch kind {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nil
+	case ExporterStderr:
+		return NewWriterExporter(os.Stderr), nil
+	case ExporterFile:
+		if path == "" {
+			return nil, fmt.Errorf("the file trace exporter needs a trace file")
+		}
+		return NewFileExporter(path)
+	default:
+		return nil, fmt.Errorf("trace exporter %q is not one of %s, %s, %s or %s", kind, ExporterNone, ExporterStdout, ExporterStderr, ExporterFile)
+	}
+}
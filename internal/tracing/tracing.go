@@ -0,0 +1,219 @@
+// Package tracing records spans of the generation pipeline: how long each
+// step takes, linked into traces through contexts and handed to an
+// Exporter. It follows the OpenTelemetry model, trimmed to what the maker
+// needs and without dependencies, so traces work offline.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status of a finished span.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// SpanData is a finished span, as exporters receive it.
+type SpanData struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Exporter receives every span when it ends. ExportSpan is called from
+// concurrent goroutines.
+type Exporter interface {
+	ExportSpan(span SpanData) error
+	Close() error
+}
+
+// Tracer starts spans and exports them. A tracer without exporter records
+// nothing.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer exporting to exporter, which may be nil.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+func init() {
+	defaultTracer.Store(NewTracer(nil))
+}
+
+// Default returns the tracer of root spans started with Start.
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+// SetDefault makes t the default tracer.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Start starts a span as a child of the span in ctx, with the tracer of
+// that span, or a root span of the default tracer. See Tracer.Start.
+func Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	if parent := SpanFromContext(ctx); parent != nil {
+		return parent.tracer.Start(ctx, name, attrs...)
+	}
+
+	return Default().Start(ctx, name, attrs...)
+}
+
+// Start starts a span as a child of the span in ctx, if any. attrs are
+// alternating keys and values, like the arguments of slog. The returned
+// context carries the span. When the tracer records nothing the span is
+// nil, which every Span method accepts.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	if t == nil || t.exporter == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			SpanID: newID(8),
+			Name:   name,
+			Start:  time.Now(),
+		},
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentID = parent.data.SpanID
+	} else {
+		span.data.TraceID = newID(16)
+	}
+
+	span.SetAttributes(attrs...)
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Span is a timed step of a trace.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SetAttributes adds alternating keys and values to the span.
+func (s *Span) SetAttributes(attrs ...interface{}) {
+	if s == nil || len(attrs) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{}, len(attrs)/2)
+	}
+
+	for i := 0; i < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok || i+1 == len(attrs) {
+			s.data.Attributes[fmt.Sprintf("!BADKEY%d", i)] = attrs[i]
+			continue
+		}
+		s.data.Attributes[key] = attrs[i+1]
+	}
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Status = StatusError
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and exports it. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.DurationMS = float64(s.data.End.Sub(s.data.Start).Microseconds()) / 1000
+	if s.data.Status == "" {
+		s.data.Status = StatusOK
+	}
+	data := s.data
+	s.mu.Unlock()
+
+	if err := s.tracer.exporter.ExportSpan(data); err != nil {
+		slog.Warn("Could not export span", "span", data.Name, "error", err)
+	}
+}
+
+// TraceID returns the ID of the trace of the span, empty for nil spans.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.data.TraceID
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, so that spans
+// started from it are its children. It links work that outlives the
+// context of a request, like a generation, to the request span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+func newID(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
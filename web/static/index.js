@@ -5,8 +5,188 @@
         const console = document.getElementById('console');
         const downloadSection = document.getElementById('download-section');
         const downloadLink = document.getElementById('download-link');
+        const downloadTarLink = document.getElementById('download-tar-link');
+        const filesSection = document.getElementById('files-section');
+        const fileTree = document.getElementById('file-tree');
+        const fileViewer = document.getElementById('file-viewer');
+        const fileViewerPath = document.getElementById('file-viewer-path');
+
+        const maxReconnects = 5;
 
         let websocket = null;
+        let session = { id: null, lastSeq: 0, finished: false, retries: 0 };
+
+        const languageSelect = document.getElementById('language');
+        const templateSelect = document.getElementById('template');
+        const templateDescription = document.getElementById('template-description');
+        const modelSelect = document.getElementById('model');
+        const addonsContainer = document.getElementById('addons');
+        const paramsContainer = document.getElementById('params');
+
+        let templates = [];
+
+        loadCatalog();
+
+        languageSelect.addEventListener('change', renderTemplates);
+        templateSelect.addEventListener('change', renderTemplateDescription);
+
+        async function loadCatalog() {
+            try {
+                const [languagesRes, templatesRes, modelsRes, addonsRes] = await Promise.all([
+                    fetch('/api/languages'),
+                    fetch('/api/templates'),
+                    fetch('/api/models'),
+                    fetch('/api/addons'),
+                ]);
+
+                const languages = await languagesRes.json();
+                templates = await templatesRes.json();
+                const models = await modelsRes.json();
+                const addons = await addonsRes.json();
+
+                addonsContainer.innerHTML = '';
+                addons.forEach((addon) => {
+                    const label = document.createElement('label');
+                    label.title = addon.description;
+                    label.classList.add('flex', 'items-center', 'gap-1');
+
+                    const checkbox = document.createElement('input');
+                    checkbox.type = 'checkbox';
+                    checkbox.value = addon.name;
+                    checkbox.dataset.language = addon.language || '';
+
+                    label.appendChild(checkbox);
+                    label.appendChild(document.createTextNode(addon.name));
+                    addonsContainer.appendChild(label);
+                });
+
+                languageSelect.innerHTML = '';
+                languages.forEach((language) => {
+                    languageSelect.appendChild(new Option(language, language));
+                });
+                if (languages.includes('go')) {
+                    languageSelect.value = 'go';
+                }
+
+                modelSelect.innerHTML = '';
+                models.forEach((model) => {
+                    modelSelect.appendChild(new Option(`${model.id} - ${model.description}`, model.id));
+                });
+
+                renderTemplates();
+            } catch (err) {
+                resultSection.classList.remove('hidden');
+                log('error', `Could not load templates and models: ${err}`);
+            }
+        }
+
+        function renderTemplates() {
+            const language = languageSelect.value.toLowerCase();
+            const previous = templateSelect.value;
+
+            templateSelect.innerHTML = '';
+            templates
+                .filter((tmpl) => (tmpl.language || '').toLowerCase() === language || tmpl.name === 'default')
+                .forEach((tmpl) => {
+                    templateSelect.appendChild(new Option(tmpl.name, tmpl.name));
+                });
+
+            if ([...templateSelect.options].some((option) => option.value === previous)) {
+                templateSelect.value = previous;
+            }
+
+            renderTemplateDescription();
+        }
+
+        function renderTemplateDescription() {
+            const tmpl = templates.find((t) => t.name === templateSelect.value);
+            templateDescription.textContent = tmpl ? tmpl.description : '';
+            renderParams(tmpl ? tmpl.parameters || [] : []);
+        }
+
+        function renderParams(parameters) {
+            paramsContainer.innerHTML = '';
+
+            parameters.forEach((param) => {
+                const wrapper = document.createElement('div');
+
+                const label = document.createElement('label');
+                label.classList.add('block', 'text-sm', 'font-medium', 'text-gray-700', 'mb-1');
+                label.innerText = param.required ? `${param.name} *` : param.name;
+                wrapper.appendChild(label);
+
+                let input;
+                const type = param.type || (param.enum ? 'enum' : 'string');
+
+                if (type === 'enum' || (param.enum && param.enum.length)) {
+                    input = document.createElement('select');
+                    param.enum.forEach((value) => input.appendChild(new Option(value, value)));
+                } else if (type === 'bool') {
+                    input = document.createElement('input');
+                    input.type = 'checkbox';
+                    input.checked = param.default === true || param.default === 'true';
+                } else {
+                    input = document.createElement('input');
+                    input.type = type === 'int' ? 'number' : 'text';
+                }
+
+                if (type !== 'bool' && param.default !== undefined && param.default !== null) {
+                    input.value = param.default;
+                }
+
+                input.dataset.param = param.name;
+                input.dataset.type = type;
+                input.required = !!param.required;
+                if (type !== 'bool') {
+                    input.classList.add('w-full', 'p-2', 'border', 'rounded');
+                }
+                wrapper.appendChild(input);
+
+                if (param.description) {
+                    const help = document.createElement('p');
+                    help.classList.add('text-xs', 'text-gray-500', 'mt-1');
+                    help.innerText = param.description;
+                    wrapper.appendChild(help);
+                }
+
+                paramsContainer.appendChild(wrapper);
+            });
+        }
+
+        function collectParams() {
+            const params = {};
+
+            paramsContainer.querySelectorAll('[data-param]').forEach((input) => {
+                if (input.dataset.type === 'bool') {
+                    params[input.dataset.param] = input.checked;
+                } else if (input.value !== '') {
+                    params[input.dataset.param] = input.dataset.type === 'int' ? parseInt(input.value, 10) : input.value;
+                }
+            });
+
+            return params;
+        }
+
+        function collectSampling() {
+            const sampling = {};
+
+            const temperature = document.getElementById('temperature').value;
+            if (temperature !== '') {
+                sampling.temperature = parseFloat(temperature);
+            }
+
+            const seed = document.getElementById('seed').value;
+            if (seed !== '') {
+                sampling.seed = parseInt(seed, 10);
+            }
+
+            const reasoningEffort = document.getElementById('reasoning-effort').value;
+            if (reasoningEffort) {
+                sampling.reasoningEffort = reasoningEffort;
+            }
+
+            return Object.keys(sampling).length ? sampling : undefined;
+        }
 
         form.addEventListener('submit', (e) => {
             e.preventDefault();
@@ -18,6 +198,7 @@
             resultSection.classList.remove('hidden');
             console.innerHTML = '';
             downloadSection.classList.add('hidden');
+            filesSection.classList.add('hidden');
 
             // Disable submit button
             generateBtn.disabled = true;
@@ -30,9 +211,12 @@
             const basePackage = document.getElementById('base-package').value;
             const workerCount = document.getElementById('worker-count').value;
             const model = document.getElementById('model').value;
+            const addons = [...addonsContainer.querySelectorAll('input:checked')].map((input) => input.value);
+
+            session = { id: null, lastSeq: 0, finished: false, retries: 0 };
 
             // Connect to WebSocket
-            websocket = new WebSocket(`ws://${window.location.host}/api/generate`);
+            websocket = new WebSocket(`${wsScheme()}//${window.location.host}/api/generate`);
 
             websocket.onopen = () => {
                 // Send request
@@ -43,46 +227,198 @@
                     basePackage,
                     workerCount: parseInt(workerCount),
                     model,
+                    addons,
+                    params: collectParams(),
+                    sampling: collectSampling(),
                     projectName: document.getElementById('project-name').value || `${language}-project`
                 }));
 
                 log('info', 'Connected to server. Starting code generation...');
             };
 
-            websocket.onmessage = (event) => {
-                const data = JSON.parse(event.data);
-
-                switch(data.type) {
-                    case 'start':
-                        log('info', data.message);
-                        break;
-                    case 'file':
-                        log('info', `Writing file: ${data.file}`);
-                        break;
-                    case 'error':
-                        log('error', `Error: ${data.error}`);
-                        generateBtn.disabled = false;
-                        generateBtn.innerText = 'Generate Code';
-                        break;
-                    case 'complete':
-                        log('success', data.message);
-                        downloadLink.href = data.zipUrl;
-                        downloadSection.classList.remove('hidden');
-                        generateBtn.disabled = false;
-                        generateBtn.innerText = 'Generate Code';
-                        break;
-                }
+            attachHandlers(websocket);
+        }
+
+        function wsScheme() {
+            return window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        }
+
+        function attachHandlers(ws) {
+            ws.onmessage = (event) => {
+                handleEvent(JSON.parse(event.data));
+            };
+
+            ws.onerror = () => {
+                log('error', 'WebSocket error');
             };
 
-            websocket.onerror = (error) => {
-                log('error', `WebSocket error: ${error}`);
-                generateBtn.disabled = false;
-                generateBtn.innerText = 'Generate Code';
+            ws.onclose = () => {
+                if (session.finished || !session.id) {
+                    log('info', 'Connection closed');
+                    finishGeneration();
+                    return;
+                }
+
+                if (session.retries >= maxReconnects) {
+                    log('error', `Connection lost. Session ${session.id} may still be running on the server.`);
+                    finishGeneration();
+                    return;
+                }
+
+                const delay = Math.min(1000 * 2 ** session.retries, 15000);
+                session.retries++;
+                log('error', `Connection lost, reconnecting in ${delay / 1000}s...`);
+                setTimeout(reconnect, delay);
             };
+        }
+
+        function reconnect() {
+            websocket = new WebSocket(`${wsScheme()}//${window.location.host}/api/reconnect`);
 
-            websocket.onclose = () => {
-                log('info', 'Connection closed');
+            websocket.onopen = () => {
+                session.retries = 0;
+                websocket.send(JSON.stringify({ sessionId: session.id, lastSeq: session.lastSeq }));
+                log('info', `Reconnected to session ${session.id}`);
             };
+
+            attachHandlers(websocket);
+        }
+
+        function handleEvent(data) {
+            if (data.seq) {
+                if (data.seq <= session.lastSeq) {
+                    return;
+                }
+                session.lastSeq = data.seq;
+            }
+
+            if (data.sessionId) {
+                session.id = data.sessionId;
+            }
+
+            switch(data.type) {
+                case 'start':
+                    log('info', data.message);
+                    break;
+                case 'file':
+                    log('info', `Writing file: ${data.file}`);
+                    break;
+                case 'warning':
+                    log('warning', data.file ? `${data.message} (${data.file})` : data.message);
+                    break;
+                case 'shutdown':
+                    log('error', data.message);
+                    break;
+                case 'usage':
+                case 'cache':
+                    log('info', data.message);
+                    break;
+                case 'error':
+                    session.finished = true;
+                    log('error', `Error: ${data.error}`);
+                    if (data.resetAt) {
+                        log('error', `You can try again after ${new Date(data.resetAt).toLocaleTimeString()}`);
+                    }
+                    finishGeneration();
+                    break;
+                case 'complete':
+                    session.finished = true;
+                    log('success', data.message);
+                    downloadLink.href = data.zipUrl;
+                    downloadTarLink.href = `${data.zipUrl}?format=tar.gz`;
+                    downloadSection.classList.remove('hidden');
+                    if (data.sessionId) {
+                        loadFiles(data.sessionId);
+                    }
+                    finishGeneration();
+                    break;
+            }
+        }
+
+        function finishGeneration() {
+            generateBtn.disabled = false;
+            generateBtn.innerText = 'Generate Code';
+        }
+
+        async function loadFiles(sessionId) {
+            const res = await fetch(`/api/sessions/${sessionId}/files`);
+            if (!res.ok) {
+                log('error', `Could not list generated files: ${res.status}`);
+                return;
+            }
+
+            const tree = await res.json();
+            fileTree.innerHTML = '';
+            fileViewer.textContent = '';
+            fileViewerPath.textContent = '';
+
+            let currentDir = null;
+            tree.files.sort((a, b) => a.path.localeCompare(b.path)).forEach((file) => {
+                const slash = file.path.lastIndexOf('/');
+                const dir = slash >= 0 ? file.path.slice(0, slash) : '';
+                const name = file.path.slice(slash + 1);
+
+                if (dir !== currentDir) {
+                    currentDir = dir;
+                    if (dir) {
+                        const dirEl = document.createElement('div');
+                        dirEl.classList.add('dir');
+                        dirEl.innerText = `${dir}/`;
+                        fileTree.appendChild(dirEl);
+                    }
+                }
+
+                const fileEl = document.createElement('div');
+                fileEl.classList.add('file');
+                fileEl.style.paddingLeft = dir ? '16px' : '4px';
+                fileEl.innerText = name;
+
+                const sizeEl = document.createElement('span');
+                sizeEl.classList.add('size');
+                sizeEl.innerText = formatSize(file.size);
+                fileEl.appendChild(sizeEl);
+
+                fileEl.addEventListener('click', () => {
+                    fileTree.querySelectorAll('.file.active').forEach((el) => el.classList.remove('active'));
+                    fileEl.classList.add('active');
+                    showFile(sessionId, file);
+                });
+                fileTree.appendChild(fileEl);
+            });
+
+            filesSection.classList.remove('hidden');
+        }
+
+        async function showFile(sessionId, file) {
+            const url = `/api/sessions/${sessionId}/files/${file.path.split('/').map(encodeURIComponent).join('/')}`;
+            const res = await fetch(url);
+
+            fileViewerPath.textContent = file.path;
+            fileViewer.className = '';
+
+            if (!res.ok) {
+                fileViewer.textContent = `Could not load file: ${res.status}`;
+                return;
+            }
+
+            const contentType = res.headers.get('Content-Type') || '';
+            if (!contentType.startsWith('text/') && !contentType.includes('json') && !contentType.includes('xml') && !contentType.includes('javascript')) {
+                fileViewer.textContent = `Binary file (${contentType}), ${formatSize(file.size)}`;
+                return;
+            }
+
+            fileViewer.textContent = await res.text();
+            fileViewer.classList.add(`language-${file.language}`);
+            if (window.hljs) {
+                hljs.highlightElement(fileViewer);
+            }
+        }
+
+        function formatSize(bytes) {
+            if (bytes < 1024) {
+                return `${bytes} B`;
+            }
+            return `${(bytes / 1024).toFixed(1)} KB`;
         }
 
         function log(type, message) {